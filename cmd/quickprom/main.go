@@ -4,72 +4,644 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/prometheus/client_golang/api"
 	"github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 
 	"github.com/pianohacker/quickprom/internal/auth"
+	"github.com/pianohacker/quickprom/internal/batch"
+	"github.com/pianohacker/quickprom/internal/cache"
 	"github.com/pianohacker/quickprom/internal/cmdline"
+	"github.com/pianohacker/quickprom/internal/exitcode"
+	"github.com/pianohacker/quickprom/internal/local"
 	"github.com/pianohacker/quickprom/internal/output"
+	"github.com/pianohacker/quickprom/internal/query"
+	"github.com/pianohacker/quickprom/internal/receive"
+	"github.com/pianohacker/quickprom/internal/retry"
+	"github.com/pianohacker/quickprom/internal/scrape"
 )
 
 func main() {
 	opts, err := cmdline.ParseOptsAndEnv(true)
-	failIfErr("Error: %s", err)
+	if err != nil {
+		failWithCode(exitcode.Usage, "Error: %s", err)
+	}
 
-	promClient := getPromClient(opts)
+	if opts.RenderEnabled {
+		runRender(opts)
+		return
+	}
 
-	ctx, _ := context.WithTimeout(context.Background(), opts.Timeout)
+	if opts.ScrapeEnabled {
+		runScrape(opts)
+		return
+	}
 
-	var value model.Value
-	if opts.RangeEnabled {
-		value, err = promClient.QueryRange(ctx, opts.Query, v1.Range{
-			Start: opts.RangeStart,
-			End:   opts.RangeEnd,
-			Step:  opts.RangeStep,
-		})
+	if opts.ReceiveEnabled {
+		runReceive(opts)
+		return
+	}
+
+	targets := getTargets(opts)
+	defer closeTsdbs()
+
+	for i := range targets {
+		targets[i].Limiter = query.NewLimiter(opts.Concurrency)
+	}
+
+	if opts.DiffEnabled {
+		runDiff(targets, opts)
+		return
+	}
+
+	if opts.QueryFile != "" {
+		runBatch(targets, opts)
+		return
+	}
+
+	if opts.CompareOffsets != nil {
+		runComparison(targets, opts)
+		return
+	}
+
+	if canStreamRange(targets, opts) {
+		runStreamedRangeQuery(targets[0], opts)
+		return
+	}
+
+	value, warnings, err := runQuery(targets, opts, opts.Query)
+	printWarnings(warnings)
+	failIfErr("Failed to run query: %s", err)
+
+	renderValue(opts, value, warnings)
+	exitIfEmpty(value)
+}
+
+// renderValue shows a query result. Warnings are only included in JSON output, so they should
+// already have been printed for the other formats.
+func renderValue(opts *cmdline.QuickPromOptions, value model.Value, warnings v1.Warnings) {
+	if opts.Format == cmdline.FormatOpenMetrics {
+		failIfErr("Failed to export result: %s", output.RenderOpenMetrics(value))
+	} else if opts.Json {
+		failIfErr("Failed to marshal result to JSON: %s", output.RenderJson(value, warnings))
 	} else {
-		value, err = promClient.Query(ctx, opts.Query, opts.Time)
+		output.FormatValue(value).RenderText(getRenderOptions(opts))
+	}
+}
+
+func runRender(opts *cmdline.QuickPromOptions) {
+	var contents []byte
+	var err error
+
+	if opts.RenderFile == "-" {
+		contents, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		contents, err = ioutil.ReadFile(opts.RenderFile)
+	}
+	failIfErr("Failed to read result: %s", err)
+
+	value, err := output.ParseJson(contents)
+	failIfErr("Failed to parse result: %s", err)
+
+	renderValue(opts, value, nil)
+}
+
+func runScrape(opts *cmdline.QuickPromOptions) {
+	roundTripper, _ := getRoundTripper(opts)
+	families, err := scrape.Fetch(opts.ScrapeUrl, roundTripper, opts.Timeout)
+	failIfErr("Failed to scrape metrics: %s", err)
+
+	if opts.Watch != 0 {
+		watchScrape(opts, roundTripper, families)
+		return
+	}
+
+	if opts.Format != cmdline.FormatText {
+		renderValue(opts, scrape.Samples(families), nil)
+		return
+	}
+
+	for i, family := range families {
+		if i != 0 {
+			fmt.Println()
+		}
+
+		description := family.Type
+		if family.Help != "" {
+			description += ", " + family.Help
+		}
+
+		output.RenderHeading(family.Name, description)
+		output.FormatInstantVector(family.Samples).RenderText(getRenderOptions(opts))
+	}
+}
+
+// watchScrape reuses the round tripper of the first scrape, so --cf-auth only asks cf for a token
+// once.
+func watchScrape(opts *cmdline.QuickPromOptions, roundTripper http.RoundTripper, previous []scrape.Family) {
+	fmt.Printf("Waiting %s to measure rates...\n", opts.WatchInput)
+
+	for range time.Tick(opts.Watch) {
+		current, err := scrape.Fetch(opts.ScrapeUrl, roundTripper, opts.Timeout)
+
+		output.ClearScreen()
+		if err != nil {
+			fmt.Printf("Failed to scrape metrics: %s\n", err)
+			continue
+		}
+
+		rates, gauges := scrape.Rates(previous, current)
+		previous = current
+
+		output.RenderHeading("Rates", "per second, over "+opts.WatchInput)
+		output.FormatInstantVector(rates).RenderText(getRenderOptions(opts))
+
+		fmt.Println()
+		output.RenderHeading("Gauges", "current values")
+		output.FormatInstantVector(gauges).RenderText(getRenderOptions(opts))
+	}
+}
+
+func runReceive(opts *cmdline.QuickPromOptions) {
+	receiver := receive.NewReceiver(opts.ReceiveMatchers)
+
+	listener, err := net.Listen("tcp", opts.ReceiveListen)
+	failIfErr("Failed to listen: %s", err)
+
+	go func() {
+		err := http.Serve(listener, receiver)
+		fail("Failed to receive: %s", err)
+	}()
+
+	fmt.Printf("Waiting for remote-write requests on %s...\n", listener.Addr())
+
+	for range time.Tick(time.Second) {
+		samples, stats, changed := receiver.Latest()
+		if !changed {
+			continue
+		}
+
+		description := fmt.Sprintf("%d requests, %d series", stats.Requests, stats.Series)
+		if stats.Skipped != 0 {
+			description += fmt.Sprintf(", %d native histogram samples not shown", stats.Skipped)
+		}
+
+		output.ClearScreen()
+		output.RenderHeading("Received", description)
+		output.FormatLatestSamples(samples).RenderText(getRenderOptions(opts))
+	}
+}
+
+func runQuery(targets []query.Target, opts *cmdline.QuickPromOptions, queryString string) (model.Value, v1.Warnings, error) {
+	if !opts.RangeEnabled {
+		return runInstantQuery(targets, opts, queryString, opts.Time)
+	}
+
+	r := v1.Range{
+		Start: opts.RangeStart,
+		End:   opts.RangeEnd,
+		Step:  opts.RangeStep,
+	}
+
+	return query.FanOut(targets, func(target query.Target) (model.Value, v1.Warnings, error) {
+		ctx, printStats := collectStats(context.Background(), opts, target, queryString)
+		defer printStats()
+
+		// Each chunk gets the full timeout, so long ranges can be queried by splitting them finely
+		return query.QueryRangeInChunks(ctx, r, opts.RangeSplit, opts.Concurrency, func(ctx context.Context, chunk v1.Range) (model.Value, v1.Warnings, error) {
+			// The timeout only starts once it's the chunk's turn
+			if err := target.Limiter.Acquire(ctx); err != nil {
+				return nil, nil, err
+			}
+			defer target.Limiter.Release()
+
+			ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+
+			return target.API.QueryRange(ctx, queryString, chunk, getQueryOptions(opts)...)
+		})
+	})
+}
+
+// canStreamRange returns whether the range query can be shown as it's read, a series at a time. It
+// has to go to a single Prometheus server in a single request, and be shown as text.
+func canStreamRange(targets []query.Target, opts *cmdline.QuickPromOptions) bool {
+	if !opts.RangeEnabled || len(targets) != 1 || targets[0].Streamer == nil || opts.Format != cmdline.FormatText {
+		return false
+	}
+
+	r := v1.Range{
+		Start: opts.RangeStart,
+		End:   opts.RangeEnd,
+		Step:  opts.RangeStep,
+	}
+
+	return len(query.SplitRange(r, opts.RangeSplit)) == 1
+}
+
+// runStreamedRangeQuery saves the result of a range query to disk, then reads it back a series at a
+// time to show it, so it never has to be held in memory whole.
+func runStreamedRangeQuery(target query.Target, opts *cmdline.QuickPromOptions) {
+	r := v1.Range{
+		Start: opts.RangeStart,
+		End:   opts.RangeEnd,
+		Step:  opts.RangeStep,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	ctx, printStats := collectStats(ctx, opts, target, opts.Query)
+	matrix, warnings, err := target.Streamer.QueryRange(ctx, opts.Query, r)
+	cancel()
+	printStats()
+
+	printWarnings(warnings)
+	failIfErr("Failed to run query: %s", err)
+
+	output.FormatRangeSeries(matrix.EachSeries).RenderText(getRenderOptions(opts))
+
+	// Exiting skips deferred calls, so the result is removed first
+	err = matrix.Err()
+	seriesCount := matrix.SeriesCount()
+	matrix.Close()
+
+	failIfErr("Failed to read result: %s", err)
+	if seriesCount == 0 {
+		exit(exitcode.Empty)
+	}
+}
+
+func runInstantQuery(targets []query.Target, opts *cmdline.QuickPromOptions, queryString string, t time.Time) (model.Value, v1.Warnings, error) {
+	return query.FanOut(targets, func(target query.Target) (model.Value, v1.Warnings, error) {
+		if err := target.Limiter.Acquire(context.Background()); err != nil {
+			return nil, nil, err
+		}
+		defer target.Limiter.Release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		defer cancel()
+
+		ctx, printStats := collectStats(ctx, opts, target, queryString)
+		defer printStats()
+
+		return target.API.Query(ctx, queryString, t, getQueryOptions(opts)...)
+	})
+}
+
+func getQueryOptions(opts *cmdline.QuickPromOptions) (queryOptions []v1.Option) {
+	if opts.QueryStats {
+		queryOptions = append(queryOptions, v1.WithStats(v1.AllStatsValue))
+	}
+
+	return
+}
+
+// collectStats returns a context that collects the statistics of a query with --query-stats, and
+// a function to print them to stderr once the query is done.
+func collectStats(ctx context.Context, opts *cmdline.QuickPromOptions, target query.Target, queryString string) (context.Context, func()) {
+	if !opts.QueryStats {
+		return ctx, func() {}
+	}
+
+	ctx, stats := query.WithStats(ctx)
+
+	return ctx, func() {
+		source := target.Name
+		if opts.QueryFile != "" {
+			// Batch queries run at once, so say which query the stats are for
+			source += " for " + queryString
+		}
+
+		if stats.Found {
+			fmt.Fprintf(os.Stderr, "Stats from %s: %s\n", source, stats)
+		} else {
+			fmt.Fprintf(os.Stderr, "No stats returned from %s\n", source)
+		}
+	}
+}
+
+func printWarnings(warnings v1.Warnings) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 	}
+}
+
+func runComparison(targets []query.Target, opts *cmdline.QuickPromOptions) {
+	value, warnings, err := runInstantQuery(targets, opts, opts.Query, opts.Time)
+	printWarnings(warnings)
 	failIfErr("Failed to run query: %s", err)
 
+	namedValues := []output.NamedValue{
+		{Name: "now", Query: opts.Query, Value: value, Warnings: warnings},
+	}
+	var comparisonNames []string
+
+	for i, offset := range opts.CompareOffsets {
+		comparisonValue, comparisonWarnings, err := runInstantQuery(targets, opts, opts.Query, opts.Time.Add(-offset))
+		printWarnings(comparisonWarnings)
+		failIfErr("Failed to run query: %s", err)
+
+		namedValues = append(namedValues, output.NamedValue{
+			Name:     opts.CompareOffsetNames[i] + " ago",
+			Query:    opts.Query,
+			Value:    comparisonValue,
+			Warnings: comparisonWarnings,
+		})
+		comparisonNames = append(comparisonNames, opts.CompareOffsetNames[i]+" ago")
+	}
+
+	if opts.Json {
+		failIfErr("Failed to marshal result to JSON: %s", output.RenderNamedJson(namedValues))
+		return
+	}
+
+	var vectors []model.Vector
+	for _, namedValue := range namedValues {
+		vector, err := query.AsVector(namedValue.Value)
+		failIfErr("Failed to compare results: %s", err)
+
+		vectors = append(vectors, vector)
+	}
+
+	output.FormatInstantVectorComparison(vectors[0], comparisonNames, vectors[1:]).RenderText(getRenderOptions(opts))
+}
+
+func runDiff(targets []query.Target, opts *cmdline.QuickPromOptions) {
+	var beforeName, afterName string
+	var before, after model.Value
+	var warnings v1.Warnings
+	var err error
+
+	if opts.DiffTimes != nil {
+		beforeName = opts.DiffTimes[0].Format(output.TimeFormatWithTZ)
+		afterName = opts.DiffTimes[1].Format(output.TimeFormatWithTZ)
+
+		before, warnings, err = runInstantQuery(targets, opts, opts.Query, opts.DiffTimes[0])
+		printWarnings(warnings)
+		failIfErr("Failed to run query: %s", err)
+
+		after, warnings, err = runInstantQuery(targets, opts, opts.Query, opts.DiffTimes[1])
+		printWarnings(warnings)
+		failIfErr("Failed to run query: %s", err)
+	} else {
+		beforeName = targets[0].Name
+		afterName = targets[1].Name
+
+		before, warnings, err = runInstantQuery(targets[:1], opts, opts.Query, opts.Time)
+		printWarnings(warnings)
+		failIfErr("Failed to run query: %s", err)
+
+		after, warnings, err = runInstantQuery(targets[1:2], opts, opts.Query, opts.Time)
+		printWarnings(warnings)
+		failIfErr("Failed to run query: %s", err)
+	}
+
+	beforeVector, err := query.AsVector(before)
+	failIfErr("Failed to compare results: %s", err)
+
+	afterVector, err := query.AsVector(after)
+	failIfErr("Failed to compare results: %s", err)
+
 	if opts.Json {
-		failIfErr("Failed to marshal result to JSON: %s", output.RenderJson(value))
+		failIfErr("Failed to marshal result to JSON: %s", output.RenderDiffJson(beforeVector, afterVector))
 	} else {
-		output.FormatValue(value).RenderText(&output.RenderOptions{
-			RangeVectorAsTable: opts.RangeTable,
+		output.FormatDiff(beforeName, beforeVector, afterName, afterVector).RenderText(getRenderOptions(opts))
+	}
+}
+
+func runBatch(targets []query.Target, opts *cmdline.QuickPromOptions) {
+	queries, err := batch.LoadFile(opts.QueryFile)
+	failIfErr("Failed to load queries: %s", err)
+
+	for i, query := range queries {
+		queries[i].Query, err = opts.ExpandQuery(query.Query, query.Params)
+		failIfErr("Failed to load queries: %s", err)
+	}
+
+	results := batch.Run(queries, opts.Concurrency, func(queryString string) (model.Value, v1.Warnings, error) {
+		return runQuery(targets, opts, queryString)
+	})
+
+	var firstErr error
+	var namedValues []output.NamedValue
+	for _, result := range results {
+		if result.Err != nil && firstErr == nil {
+			firstErr = result.Err
+		}
+
+		namedValues = append(namedValues, output.NamedValue{
+			Name:     result.Name,
+			Query:    result.Query.Query,
+			Value:    result.Value,
+			Warnings: result.Warnings,
+			Err:      result.Err,
 		})
 	}
+
+	if opts.Json {
+		failIfErr("Failed to marshal result to JSON: %s", output.RenderNamedJson(namedValues))
+	} else {
+		for i, namedValue := range namedValues {
+			if i != 0 {
+				fmt.Println()
+			}
+
+			for _, warning := range namedValue.Warnings {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", namedValue.Name, warning)
+			}
+
+			output.RenderHeading(namedValue.Name, namedValue.Query)
+
+			if namedValue.Err != nil {
+				fmt.Printf("Failed to run query: %s\n", namedValue.Err)
+			} else {
+				output.FormatValue(namedValue.Value).RenderText(getRenderOptions(opts))
+			}
+		}
+	}
+
+	if firstErr != nil {
+		exit(exitcode.ForError(firstErr))
+	}
+}
+
+func getRenderOptions(opts *cmdline.QuickPromOptions) *output.RenderOptions {
+	return &output.RenderOptions{
+		RangeLayout:      output.RangeLayout(opts.RangeLayout),
+		RangeVectorStats: opts.RangeStats,
+		RawBuckets:       opts.RawBuckets,
+		SeparateScales:   opts.SeparateScales,
+		FixedPrecision:   opts.PrecisionInput != "",
+		Precision:        opts.Precision,
+		SigFigs:          opts.SigFigs,
+		TerminalWidth:    output.TerminalWidth(),
+	}
 }
 
 func fail(msg string, args ...interface{}) {
+	failWithCode(exitcode.Failure, msg, args...)
+}
+
+func failWithCode(code int, msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg+"\n", args...)
-	os.Exit(1)
+	exit(code)
 }
 
+// failIfErr exits with the code that best describes `err`, such as a timeout or server error.
 func failIfErr(msg string, err error) {
 	if err == nil {
 		return
 	}
 
-	fail(msg, err)
+	failWithCode(exitcode.ForError(err), msg, err)
+}
+
+func exit(code int) {
+	closeTsdbs()
+	os.Exit(code)
+}
+
+// exitIfEmpty exits with a distinct code if a query returned no data, so scripts can tell that
+// apart from a failure.
+func exitIfEmpty(value model.Value) {
+	switch v := value.(type) {
+	case model.Vector:
+		if len(v) == 0 {
+			exit(exitcode.Empty)
+		}
+	case model.Matrix:
+		if len(v) == 0 {
+			exit(exitcode.Empty)
+		}
+	}
+}
+
+func getTargets(opts *cmdline.QuickPromOptions) (targets []query.Target) {
+	if opts.LocalEnabled {
+		return getLocalTargets(opts)
+	}
+
+	if opts.TsdbDir != "" {
+		return getTsdbTargets(opts)
+	}
+
+	roundTripper, credentials := getRoundTripper(opts)
+
+	if opts.RemoteRead != "" {
+		storage, err := local.NewRemoteRead(opts.RemoteRead, roundTripper, opts.Timeout)
+		failIfErr("Failed to set up remote read: %s", err)
+
+		return withCache(opts, credentials, []query.Target{
+			{
+				Name: opts.RemoteRead,
+				API:  local.NewAPI(storage),
+			},
+		})
+	}
+
+	for _, target := range opts.Targets {
+		streamer, err := query.NewRangeStreamer(target, roundTripper)
+		failIfErr("Failed to initialize Prometheus API: %s", err)
+
+		targets = append(targets, query.Target{
+			Name:     target,
+			API:      getPromClient(target, roundTripper),
+			Streamer: streamer,
+		})
+	}
+
+	return withCache(opts, credentials, targets)
 }
 
-func getPromClient(opts *cmdline.QuickPromOptions) v1.API {
+// withCache answers queries from the on-disk cache with --cache. Statistics only come from really
+// running a query, so --query-stats skips the cache.
+func withCache(opts *cmdline.QuickPromOptions, credentials string, targets []query.Target) []query.Target {
+	if opts.Cache == 0 || opts.QueryStats {
+		return targets
+	}
+
+	dir, err := cache.DefaultDir()
+	failIfErr("Failed to find cache directory: %s", err)
+
+	resultCache := cache.New(dir, opts.Cache)
+	for i := range targets {
+		targets[i].API = resultCache.API(targets[i].Name, credentials, targets[i].API)
+		// Streamed results would skip the cache
+		targets[i].Streamer = nil
+	}
+
+	return targets
+}
+
+func getLocalTargets(opts *cmdline.QuickPromOptions) []query.Target {
+	storage, err := local.LoadFile(opts.LocalData, time.Now())
+	failIfErr("Failed to load local data: %s", err)
+
+	defaultToNewestData(opts, storage.MaxTime())
+
+	return []query.Target{
+		{
+			Name: opts.LocalData,
+			API:  local.NewAPI(storage),
+		},
+	}
+}
+
+// Data directories are closed before exiting, so their sandboxes are cleaned up
+var openedTsdbs []*local.TSDB
+
+func getTsdbTargets(opts *cmdline.QuickPromOptions) []query.Target {
+	tsdb, err := local.OpenTSDB(opts.TsdbDir)
+	failIfErr("Failed to open data directory: %s", err)
+	openedTsdbs = append(openedTsdbs, tsdb)
+
+	defaultToNewestData(opts, tsdb.MaxTime())
+
+	return []query.Target{
+		{
+			Name: opts.TsdbDir,
+			API:  local.NewAPI(tsdb),
+		},
+	}
+}
+
+func closeTsdbs() {
+	for _, tsdb := range openedTsdbs {
+		tsdb.Close()
+	}
+	openedTsdbs = nil
+}
+
+func defaultToNewestData(opts *cmdline.QuickPromOptions, newest time.Time) {
+	// Saved data is usually older than the default lookback from now
+	if len(opts.TimeInputs) == 0 {
+		opts.Time = newest
+	}
+	if opts.RangeEnabled && opts.RangeEndInput == "" {
+		opts.RangeEnd = newest
+	}
+}
+
+func getPromClient(target string, roundTripper http.RoundTripper) query.API {
 	apiClient, err := api.NewClient(api.Config{
-		Address:      opts.Target,
-		RoundTripper: getRoundTripper(opts),
+		Address:      target,
+		RoundTripper: query.StatsRoundTripper(roundTripper),
 	})
 	failIfErr("Failed to initialize Prometheus API: %s", err)
 
 	return v1.NewAPI(apiClient)
 }
 
-func getRoundTripper(opts *cmdline.QuickPromOptions) http.RoundTripper {
+// getRoundTripper returns the round tripper for requests to targets, along with the credentials
+// they're made with, if any.
+func getRoundTripper(opts *cmdline.QuickPromOptions) (http.RoundTripper, string) {
 	var roundTripper http.RoundTripper = api.DefaultRoundTripper
+	var credentials string
 
 	if opts.SkipTlsVerify {
 		roundTripper.(*http.Transport).TLSClientConfig = &tls.Config{
@@ -79,11 +651,20 @@ func getRoundTripper(opts *cmdline.QuickPromOptions) http.RoundTripper {
 
 	if opts.CfAuth {
 		var err error
-		roundTripper, err = auth.CfAuthRoundTripper(roundTripper)
-		failIfErr("Error: %s", err)
+		credentials, err = auth.CfOauthToken()
+		if err != nil {
+			failWithCode(exitcode.Auth, "Error: %s", err)
+		}
+
+		roundTripper = auth.TokenAuthRoundTripper(credentials, roundTripper)
 	} else if opts.BasicAuth != "" {
+		credentials = opts.BasicAuth
 		roundTripper = auth.BasicAuthRoundTripper(opts.BasicAuth, roundTripper)
 	}
 
-	return roundTripper
+	if opts.Retries > 0 {
+		roundTripper = retry.NewRoundTripper(roundTripper, opts.Retries)
+	}
+
+	return roundTripper, credentials
 }
@@ -0,0 +1,127 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+)
+
+type receivedRequest struct {
+	Method      string
+	Path        string
+	ContentType string
+	Query       string
+}
+
+var _ = Describe("Requests", func() {
+	var compiledPath string
+	var server *httptest.Server
+	var requests []receivedRequest
+	var refusePost bool
+	var lock sync.Mutex
+
+	BeforeEach(func() {
+		var err error
+		compiledPath, err = gexec.Build("github.com/pianohacker/quickprom/cmd/quickprom")
+		Expect(err).ToNot(HaveOccurred())
+
+		requests = nil
+		refusePost = false
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+
+			lock.Lock()
+			requests = append(requests, receivedRequest{
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				ContentType: r.Header.Get("Content-Type"),
+				Query:       r.Form.Get("query"),
+			})
+			lock.Unlock()
+
+			if refusePost && r.Method == http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path == "/api/v1/query_range" {
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "matrix", "result": [
+					{"metric": {"job": "a"}, "values": [[1704067200, "1"]]}
+				]}}`)
+			} else {
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [
+					{"metric": {"job": "a"}, "value": [1704067200, "1"]}
+				]}}`)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		gexec.CleanupBuildArtifacts()
+	})
+
+	run := func(args ...string) *gexec.Session {
+		// Retries and stats put every round tripper quickprom uses in front of the requests
+		args = append([]string{"-t", server.URL, "--retries", "1", "--query-stats"}, args...)
+
+		session, err := gexec.Start(exec.Command(compiledPath, args...), GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+
+		return session.Wait(10)
+	}
+
+	It("sends instant queries as form POSTs", func() {
+		Expect(run("up").ExitCode()).To(Equal(0))
+
+		Expect(requests).To(Equal([]receivedRequest{
+			{Method: "POST", Path: "/api/v1/query", ContentType: "application/x-www-form-urlencoded", Query: "up"},
+		}))
+	})
+
+	It("sends range queries as form POSTs", func() {
+		Expect(run("range", "up", "--start", "2024-01-01 00:00", "--end", "2024-01-01 01:00", "--step", "1m").ExitCode()).To(Equal(0))
+
+		Expect(requests).To(Equal([]receivedRequest{
+			{Method: "POST", Path: "/api/v1/query_range", ContentType: "application/x-www-form-urlencoded", Query: "up"},
+		}))
+	})
+
+	It("asks again with GET when POST isn't allowed", func() {
+		refusePost = true
+
+		Expect(run("up").ExitCode()).To(Equal(0))
+
+		Expect(requests).To(Equal([]receivedRequest{
+			{Method: "POST", Path: "/api/v1/query", ContentType: "application/x-www-form-urlencoded", Query: "up"},
+			{Method: "GET", Path: "/api/v1/query", Query: "up"},
+		}))
+	})
+
+	It("asks again with GET when POST isn't allowed for range queries", func() {
+		refusePost = true
+
+		Expect(run("range", "up", "--start", "2024-01-01 00:00", "--end", "2024-01-01 01:00", "--step", "1m").ExitCode()).To(Equal(0))
+
+		Expect(requests).To(Equal([]receivedRequest{
+			{Method: "POST", Path: "/api/v1/query_range", ContentType: "application/x-www-form-urlencoded", Query: "up"},
+			{Method: "GET", Path: "/api/v1/query_range", Query: "up"},
+		}))
+	})
+
+	It("sends range queries shown as JSON the same way", func() {
+		Expect(run("--json", "range", "up", "--start", "2024-01-01 00:00", "--end", "2024-01-01 01:00", "--step", "1m").ExitCode()).To(Equal(0))
+
+		Expect(requests).To(Equal([]receivedRequest{
+			{Method: "POST", Path: "/api/v1/query_range", ContentType: "application/x-www-form-urlencoded", Query: "up"},
+		}))
+	})
+})
@@ -9,28 +9,33 @@ import (
 	"strings"
 )
 
-func CfAuthRoundTripper(innerRoundTripper http.RoundTripper) (http.RoundTripper, error) {
+// CfOauthToken gets the authorization header of the user logged in to the `cf` CLI.
+func CfOauthToken() (string, error) {
 	getTokenCommand := exec.Command("cf", "oauth-token")
 	getTokenOutput, err := getTokenCommand.StdoutPipe()
 	err = getTokenCommand.Start()
 	if err != nil {
-		return nil, fmt.Errorf("failed to launch `cf oauth-token`: %s", err)
+		return "", fmt.Errorf("failed to launch `cf oauth-token`: %s", err)
 	}
 
 	tokenBytes, err := ioutil.ReadAll(getTokenOutput)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from `cf oauth-token`: %s", err)
+		return "", fmt.Errorf("failed to read from `cf oauth-token`: %s", err)
 	}
 
 	err = getTokenCommand.Wait()
 	if err != nil {
-		return nil, fmt.Errorf("failed to run `cf oauth-token`: %s", err)
+		return "", fmt.Errorf("failed to run `cf oauth-token`: %s", err)
 	}
 
+	return strings.TrimRight(string(tokenBytes), "\r\n"), nil
+}
+
+func TokenAuthRoundTripper(token string, innerRoundTripper http.RoundTripper) http.RoundTripper {
 	return &authRoundTripper{
-		authorization:     strings.TrimRight(string(tokenBytes), "\r\n"),
+		authorization:     token,
 		innerRoundTripper: innerRoundTripper,
-	}, nil
+	}
 }
 
 func BasicAuthRoundTripper(basicAuth string, innerRoundTripper http.RoundTripper) http.RoundTripper {
@@ -0,0 +1,149 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type Query struct {
+	Name  string
+	Query string
+	// Parameters of a saved query, in `NAME=VALUE` form
+	Params []string
+}
+
+// queryEntry is a query in a batch file: either a string, or a saved query with a map of parameters.
+type queryEntry struct {
+	Query  string            `yaml:"query"`
+	Params map[string]string `yaml:"params"`
+}
+
+func (e *queryEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if unmarshal(&e.Query) == nil {
+		return nil
+	}
+
+	type plainEntry queryEntry
+	return unmarshal((*plainEntry)(e))
+}
+
+type Result struct {
+	Query
+	Value    model.Value
+	Warnings v1.Warnings
+	Err      error
+}
+
+type QueryFunc func(query string) (model.Value, v1.Warnings, error)
+
+// LoadFile reads a YAML file mapping query names to queries. The order of the file is kept, so
+// results are rendered in the same order they were written in.
+func LoadFile(path string) ([]Query, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(contents)
+}
+
+// Parse reads a batch file. Saved queries can be given parameters after their name, separated by
+// spaces, or as a map under `params`, which allows values with spaces.
+func Parse(contents []byte) ([]Query, error) {
+	// The order of the file is read separately, as the entries can't be decoded as a MapSlice
+	var order yaml.MapSlice
+	err := yaml.Unmarshal(contents, &order)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]queryEntry
+	err = yaml.UnmarshalStrict(contents, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(order) == 0 {
+		return nil, errors.New("no queries found")
+	}
+
+	var queries []Query
+	for _, item := range order {
+		name, ok := item.Key.(string)
+		if !ok {
+			return nil, fmt.Errorf("query name %v is not a string", item.Key)
+		}
+
+		entry := entries[name]
+		if entry.Query == "" {
+			return nil, fmt.Errorf("query %s must be a non-empty string", name)
+		}
+
+		query := Query{
+			Name:  name,
+			Query: entry.Query,
+		}
+
+		if entry.Params != nil {
+			for paramName, value := range entry.Params {
+				query.Params = append(query.Params, paramName+"="+value)
+			}
+			sort.Strings(query.Params)
+		} else if strings.HasPrefix(entry.Query, "@") {
+			fields := strings.Fields(entry.Query)
+			query.Query = fields[0]
+			query.Params = fields[1:]
+		}
+
+		queries = append(queries, query)
+	}
+
+	return queries, nil
+}
+
+// Run runs the given queries with at most `concurrency` in flight at once. Results are returned in
+// the same order as the queries.
+func Run(queries []Query, concurrency int, queryFunc QueryFunc) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]Result, len(queries))
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				value, warnings, err := queryFunc(queries[i].Query)
+
+				results[i] = Result{
+					Query:    queries[i],
+					Value:    value,
+					Warnings: warnings,
+					Err:      err,
+				}
+			}
+		}()
+	}
+
+	for i := range queries {
+		indices <- i
+	}
+	close(indices)
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,134 @@
+package batch_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/batch"
+)
+
+var _ = Describe("Batch", func() {
+	Describe("Parse()", func() {
+		It("keeps queries in file order", func() {
+			queries, err := batch.Parse([]byte(`
+up: up{job="node"}
+errors: sum(rate(http_errors_total[5m]))
+available: avg_over_time(up[1h])
+`))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(queries).To(Equal([]batch.Query{
+				{Name: "up", Query: `up{job="node"}`},
+				{Name: "errors", Query: "sum(rate(http_errors_total[5m]))"},
+				{Name: "available", Query: "avg_over_time(up[1h])"},
+			}))
+		})
+
+		It("reads the parameters of saved queries", func() {
+			queries, err := batch.Parse([]byte(`
+p99: "@latency q=0.99 job=api"
+search_p99:
+  query: "@latency"
+  params:
+    q: 0.99
+    job: search api
+up: up
+`))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(queries).To(Equal([]batch.Query{
+				{Name: "p99", Query: "@latency", Params: []string{"q=0.99", "job=api"}},
+				{Name: "search_p99", Query: "@latency", Params: []string{"job=search api", "q=0.99"}},
+				{Name: "up", Query: "up"},
+			}))
+		})
+
+		It("returns an error for an unknown query field", func() {
+			_, err := batch.Parse([]byte(`
+up:
+  query: up
+  param: {}
+`))
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error for an empty file", func() {
+			_, err := batch.Parse([]byte(""))
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error for a non-string query", func() {
+			_, err := batch.Parse([]byte("nested:\n  a: b\n"))
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error for a file that isn't a mapping", func() {
+			_, err := batch.Parse([]byte("- up\n- down\n"))
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Run()", func() {
+		It("returns results in query order", func() {
+			queries := []batch.Query{
+				{Name: "a", Query: "1"},
+				{Name: "b", Query: "fail"},
+				{Name: "c", Query: "3"},
+			}
+
+			results := batch.Run(queries, 2, func(query string) (model.Value, v1.Warnings, error) {
+				if query == "fail" {
+					return nil, nil, errors.New("failed")
+				}
+
+				return &model.Scalar{Value: 1}, v1.Warnings{"partial response"}, nil
+			})
+
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Name).To(Equal("a"))
+			Expect(results[0].Err).ToNot(HaveOccurred())
+			Expect(results[1].Name).To(Equal("b"))
+			Expect(results[1].Err).To(HaveOccurred())
+			Expect(results[2].Name).To(Equal("c"))
+			Expect(results[2].Value).To(Equal(&model.Scalar{Value: 1}))
+			Expect(results[2].Warnings).To(Equal(v1.Warnings{"partial response"}))
+		})
+
+		It("runs no more than the given number of queries at once", func() {
+			var queries []batch.Query
+			for i := 0; i < 10; i++ {
+				queries = append(queries, batch.Query{Name: "q", Query: "q"})
+			}
+
+			var running, maxRunning int32
+			batch.Run(queries, 3, func(query string) (model.Value, v1.Warnings, error) {
+				current := atomic.AddInt32(&running, 1)
+				for {
+					seen := atomic.LoadInt32(&maxRunning)
+					if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+						break
+					}
+				}
+
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+
+				return nil, nil, nil
+			})
+
+			Expect(maxRunning).To(BeNumerically("<=", 3))
+			Expect(maxRunning).To(BeNumerically(">", 1))
+		})
+	})
+})
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/pianohacker/quickprom/internal/output"
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+// HistoricalAge is how far in the past a query has to end for its result to be kept forever, as
+// data that old isn't expected to change.
+const HistoricalAge = 24 * time.Hour
+
+// Bumped whenever the format of keys or entries changes, so old entries are ignored
+const keyVersion = 2
+
+// Cache stores query results on disk, each in a file named by a hash of everything that determines
+// the result.
+type Cache struct {
+	dir string
+	ttl time.Duration
+}
+
+// DefaultDir returns the directory results are cached in, `quickprom` in the platform's cache
+// directory, such as `~/.cache/quickprom` on Linux.
+func DefaultDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "quickprom"), nil
+}
+
+// New returns a cache in `dir` whose results are reused for `ttl`, or forever for historical
+// queries.
+func New(dir string, ttl time.Duration) *Cache {
+	return &Cache{
+		dir: dir,
+		ttl: ttl,
+	}
+}
+
+type key struct {
+	Version int    `json:"version"`
+	Target  string `json:"target"`
+	// Multi-tenant servers like Cortex and Mimir answer differently depending on who's asking. Keys
+	// are only ever stored hashed, so the credentials aren't either.
+	Credentials string `json:"credentials"`
+	Query       string `json:"query"`
+	Time        int64  `json:"time"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+	Step        int64  `json:"step"`
+}
+
+func (k key) path(dir string) string {
+	encoded, _ := json.Marshal(k)
+	hash := sha256.Sum256(encoded)
+
+	return filepath.Join(dir, hex.EncodeToString(hash[:])+".json")
+}
+
+type entry struct {
+	StoredAt   time.Time       `json:"storedAt"`
+	Historical bool            `json:"historical"`
+	ResultType model.ValueType `json:"resultType"`
+	Result     model.Value     `json:"result"`
+}
+
+func (c *Cache) get(k key) (model.Value, bool) {
+	contents, err := ioutil.ReadFile(k.path(c.dir))
+	if err != nil {
+		return nil, false
+	}
+
+	var metadata struct {
+		StoredAt   time.Time `json:"storedAt"`
+		Historical bool      `json:"historical"`
+	}
+	if json.Unmarshal(contents, &metadata) != nil {
+		return nil, false
+	}
+
+	if !metadata.Historical && time.Since(metadata.StoredAt) >= c.ttl {
+		return nil, false
+	}
+
+	value, err := output.ParseJson(contents)
+	if err != nil {
+		return nil, false
+	}
+
+	return value, true
+}
+
+func (c *Cache) put(k key, end time.Time, value model.Value) error {
+	now := time.Now()
+	contents, err := json.Marshal(&entry{
+		StoredAt:   now,
+		Historical: now.Sub(end) > HistoricalAge,
+		ResultType: value.Type(),
+		Result:     value,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(c.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Written to a temporary file first, so other runs never see half an entry
+	file, err := ioutil.TempFile(c.dir, "entry")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), k.path(c.dir))
+}
+
+type cachedAPI struct {
+	cache       *Cache
+	target      string
+	credentials string
+	api         query.API
+}
+
+// API returns an API that answers queries to `target` made with `credentials` from the cache when
+// it can, and caches the results of `api` otherwise. Results with warnings aren't cached, as they
+// may be incomplete.
+func (c *Cache) API(target, credentials string, api query.API) query.API {
+	return &cachedAPI{
+		cache:       c,
+		target:      target,
+		credentials: credentials,
+		api:         api,
+	}
+}
+
+func (a *cachedAPI) Query(ctx context.Context, queryString string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	k := key{
+		Version:     keyVersion,
+		Target:      a.target,
+		Credentials: a.credentials,
+		Query:       queryString,
+		Time:        ts.UnixMilli(),
+	}
+
+	return a.cached(k, ts, func() (model.Value, v1.Warnings, error) {
+		return a.api.Query(ctx, queryString, ts, opts...)
+	})
+}
+
+func (a *cachedAPI) QueryRange(ctx context.Context, queryString string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	k := key{
+		Version:     keyVersion,
+		Target:      a.target,
+		Credentials: a.credentials,
+		Query:       queryString,
+		Start:       r.Start.UnixMilli(),
+		End:         r.End.UnixMilli(),
+		Step:        r.Step.Milliseconds(),
+	}
+
+	return a.cached(k, r.End, func() (model.Value, v1.Warnings, error) {
+		return a.api.QueryRange(ctx, queryString, r, opts...)
+	})
+}
+
+func (a *cachedAPI) cached(k key, end time.Time, queryFunc func() (model.Value, v1.Warnings, error)) (model.Value, v1.Warnings, error) {
+	if value, ok := a.cache.get(k); ok {
+		return value, nil, nil
+	}
+
+	value, warnings, err := queryFunc()
+	if err != nil || len(warnings) != 0 {
+		return value, warnings, err
+	}
+
+	// The query itself succeeded, so failing to cache it is no reason to fail
+	a.cache.put(k, end, value)
+
+	return value, nil, nil
+}
@@ -0,0 +1,163 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/cache"
+)
+
+type fakeAPI struct {
+	calls    int
+	value    model.Value
+	warnings v1.Warnings
+	err      error
+}
+
+func (f *fakeAPI) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	f.calls++
+
+	return f.value, f.warnings, f.err
+}
+
+func (f *fakeAPI) QueryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	f.calls++
+
+	return f.value, f.warnings, f.err
+}
+
+var _ = Describe("Cache", func() {
+	var dir string
+	var api *fakeAPI
+
+	ctx := context.Background()
+	recent := time.Now().Truncate(time.Millisecond)
+	historical := recent.Add(-2 * cache.HistoricalAge)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "quickprom-cache")
+		Expect(err).ToNot(HaveOccurred())
+
+		api = &fakeAPI{
+			value: model.Vector{
+				{Metric: model.Metric{"job": "a"}, Value: 1, Timestamp: model.TimeFromUnixNano(recent.UnixNano())},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("answers a repeated query from the cache", func() {
+		cachedAPI := cache.New(dir, time.Hour).API("target", "", api)
+
+		first, _, err := cachedAPI.Query(ctx, "up", recent)
+		Expect(err).ToNot(HaveOccurred())
+
+		second, _, err := cache.New(dir, time.Hour).API("target", "", api).Query(ctx, "up", recent)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(second).To(Equal(first))
+		Expect(api.calls).To(Equal(1))
+	})
+
+	It("caches range queries", func() {
+		api.value = model.Matrix{
+			{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}},
+		}
+		cachedAPI := cache.New(dir, time.Hour).API("target", "", api)
+		r := v1.Range{Start: recent.Add(-time.Hour), End: recent, Step: time.Minute}
+
+		cachedAPI.QueryRange(ctx, "up", r)
+		value, _, err := cachedAPI.QueryRange(ctx, "up", r)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal(api.value))
+		Expect(api.calls).To(Equal(1))
+	})
+
+	It("runs queries that differ in target, credentials, query, time or step", func() {
+		cachedAPI := cache.New(dir, time.Hour).API("target", "", api)
+		r := v1.Range{Start: recent.Add(-time.Hour), End: recent, Step: time.Minute}
+
+		cachedAPI.Query(ctx, "up", recent)
+		cache.New(dir, time.Hour).API("other-target", "", api).Query(ctx, "up", recent)
+		cache.New(dir, time.Hour).API("target", "tenant-a:password", api).Query(ctx, "up", recent)
+		cache.New(dir, time.Hour).API("target", "tenant-b:password", api).Query(ctx, "up", recent)
+		cachedAPI.Query(ctx, "down", recent)
+		cachedAPI.Query(ctx, "up", recent.Add(time.Second))
+		cachedAPI.QueryRange(ctx, "up", r)
+		cachedAPI.QueryRange(ctx, "up", v1.Range{Start: r.Start, End: r.End, Step: time.Second})
+
+		Expect(api.calls).To(Equal(8))
+	})
+
+	It("runs queries again once their results are older than the TTL", func() {
+		cachedAPI := cache.New(dir, 50*time.Millisecond).API("target", "", api)
+
+		cachedAPI.Query(ctx, "up", recent)
+		time.Sleep(100 * time.Millisecond)
+		cachedAPI.Query(ctx, "up", recent)
+
+		Expect(api.calls).To(Equal(2))
+	})
+
+	It("keeps the results of historical queries past the TTL", func() {
+		cachedAPI := cache.New(dir, 50*time.Millisecond).API("target", "", api)
+
+		cachedAPI.Query(ctx, "up", historical)
+		time.Sleep(100 * time.Millisecond)
+		cachedAPI.Query(ctx, "up", historical)
+
+		Expect(api.calls).To(Equal(1))
+	})
+
+	It("doesn't cache results with warnings", func() {
+		api.warnings = v1.Warnings{"partial response"}
+		cachedAPI := cache.New(dir, time.Hour).API("target", "", api)
+
+		cachedAPI.Query(ctx, "up", recent)
+		_, warnings, _ := cachedAPI.Query(ctx, "up", recent)
+
+		Expect(warnings).To(Equal(v1.Warnings{"partial response"}))
+		Expect(api.calls).To(Equal(2))
+	})
+
+	It("doesn't cache errors", func() {
+		api.err = errors.New("timed out")
+		cachedAPI := cache.New(dir, time.Hour).API("target", "", api)
+
+		cachedAPI.Query(ctx, "up", recent)
+		_, _, err := cachedAPI.Query(ctx, "up", recent)
+
+		Expect(err).To(MatchError("timed out"))
+		Expect(api.calls).To(Equal(2))
+	})
+
+	It("runs queries whose cache entry is corrupt", func() {
+		cachedAPI := cache.New(dir, time.Hour).API("target", "", api)
+		cachedAPI.Query(ctx, "up", recent)
+
+		entries, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(ioutil.WriteFile(filepath.Join(dir, entries[0].Name()), []byte("{"), 0600)).To(Succeed())
+
+		_, _, err = cachedAPI.Query(ctx, "up", recent)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(api.calls).To(Equal(2))
+	})
+})
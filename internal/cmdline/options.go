@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,35 +13,157 @@ import (
 	fuzzytime "github.com/bcampbell/fuzzytime"
 	docopt "github.com/docopt/docopt-go"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/pianohacker/quickprom/internal/config"
+	"github.com/pianohacker/quickprom/internal/exitcode"
 )
 
 const USAGE = `quickprom - run queries against Prometheus-compatible databases
 
 Usage:
-  quickprom [options] QUERY [--time TIME]
-  quickprom [options] range QUERY --start START [--end END] --step STEP
+  quickprom [options] render FILE
+  quickprom [options] scrape URL
+  quickprom [options] receive --listen ADDR [SELECTOR]
+  quickprom [options] local --data FILE (QUERY [PARAM...] | --file FILE) [--time TIME]
+  quickprom [options] local --data FILE range (QUERY [PARAM...] | --file FILE) --start START [--end END] --step STEP
+  quickprom [options] [--target TARGET]... diff QUERY [PARAM...] [--time TIME]...
+  quickprom [options] [--target TARGET]... (QUERY [PARAM...] | --file FILE) [--time TIME]
+  quickprom [options] [--target TARGET]... range (QUERY [PARAM...] | --file FILE) --start START [--end END] --step STEP
 
 Global options:
-  -t, --target TARGET        URL of Prometheus-compatible target 
-                             (QUICKPROM_TARGET)
+  -t, --target TARGET        URL of Prometheus-compatible target (QUICKPROM_TARGET);
+                             can be given more than once to query several
+                             targets at once and label each series with its
+                             ` + "`target`" + `
+  --tsdb-dir PATH            Evaluate queries locally against the Prometheus
+                             data directory or block at ` + "`PATH`" + `, opened
+                             read-only, instead of a target
+                             (QUICKPROM_TSDB_DIR)
+  --remote-read URL          Fetch raw samples from the Prometheus remote-read
+                             endpoint at ` + "`URL`" + ` and evaluate queries locally,
+                             instead of a target (QUICKPROM_REMOTE_READ)
   -k, --skip-tls-verify      Don't verify remote certificate 
                              (QUICKPROM_SKIP_TLS_VERIFY)
   --basic-auth USER:PASS     Use basic authentication (QUICKPROM_BASIC_AUTH)
   --cf-auth                  Automatically use current oAuth token from ` + "`cf`" + `
                              (QUICKPROM_CF_AUTH)
   --json                     Output JSON result (QUICKPROM_JSON)
-  -b, --range-table          Output range vectors as tables (QUICKPROM_RANGE_TABLE)
+  --format FORMAT            Output ` + "`text`" + `, ` + "`json`" + ` or ` + "`openmetrics`" + ` exposition
+                             text with timestamps (QUICKPROM_FORMAT, defaults
+                             to text)
+  --range-layout LAYOUT      Output range vectors as a ` + "`table`" + ` with a column for
+                             each time, split into several tables if too wide
+                             for the terminal, ` + "`transposed`" + ` with a column for each
+                             series, or a ` + "`list`" + `; ` + "`auto`" + ` uses a table if it fits the
+                             terminal, then a transposed table, then a list
+                             (QUICKPROM_RANGE_LAYOUT, defaults to auto)
+  -b, --range-table          Same as --range-layout table
+                             (QUICKPROM_RANGE_TABLE)
+  --separate-scales          Choose how to format numbers for each series of a
+                             range vector, and each value column of other
+                             results, rather than once for the whole result
+                             (QUICKPROM_SEPARATE_SCALES)
+  --precision N              Show values with ` + "`N`" + ` digits after the decimal point
+                             (QUICKPROM_PRECISION)
+  --sig-figs N               Show values with ` + "`N`" + ` significant figures
+                             (QUICKPROM_SIG_FIGS)
+  --query-stats              Print the number of samples each query touched and
+                             how long it took on the server to stderr
+                             (QUICKPROM_QUERY_STATS)
   --timeout DURATION         Maximum time to wait for response from server
                              (QUICKPROM_TIMEOUT, defaults to 5s)
+  --retries N                Retry network errors and 5xx and 429 responses up
+                             to ` + "`N`" + ` times, backing off exponentially or as asked
+                             by Retry-After, within --timeout
+                             (QUICKPROM_RETRIES, defaults to 0)
+  --cache TTL                Reuse results of identical queries to a target
+                             saved in the last ` + "`TTL`" + `, or ever for queries ending
+                             more than a day ago (QUICKPROM_CACHE)
+  --no-cache                 Don't use or save cached results, even with
+                             QUICKPROM_CACHE set
+  --config FILE              Load saved queries from ` + "`FILE`" + ` (QUICKPROM_CONFIG,
+                             defaults to quickprom/config.yml in the platform
+                             config directory, such as ~/.config on Linux)
+
+Batch options:
+  -f, --file FILE            Run all the named queries in the YAML file ` + "`FILE`" + `
+  --concurrency N            Maximum number of queries, counting each chunk of
+                             split range queries, to send each target at once
+                             (QUICKPROM_CONCURRENCY, defaults to 4)
 
 Instant query options:
   -i, --time TIME            Evaluate instant query at ` + "`TIME`" + `
                              (defaults to now)
+  --raw-buckets              Show histogram buckets as plain samples instead
+                             of as a distribution (QUICKPROM_RAW_BUCKETS)
+  --compare-offset OFFSETS   Also show the value of each sample at each of the
+                             comma-separated ` + "`OFFSETS`" + ` before ` + "`TIME`" + `
+                             (for example, 1d,1w)
 
 Range query options:
   -s, --start START          Start time of range query
   -e, --end END              End time of range query (inclusive, defaults to now)
   -p, --step STEP            Step of range query
+  --split DURATION           Split range queries into chunks covering at most
+                             ` + "`DURATION`" + ` each, run --concurrency at a time
+                             (QUICKPROM_SPLIT, defaults to 11,000 steps, the
+                             most Prometheus returns at once)
+  --stats                    Summarize each series instead of showing every
+                             value (QUICKPROM_STATS)
+
+Local query options:
+  -d, --data FILE            Evaluate queries locally against the samples in
+                             ` + "`FILE`" + `, in the OpenMetrics or Prometheus text format;
+                             ` + "`--time`" + ` and ` + "`--end`" + ` default to the newest sample
+
+Scrape options:
+  -w, --watch INTERVAL       Scrape again every ` + "`INTERVAL`" + ` and show the per-second
+                             rates of counters, sorted by rate, and the
+                             current values of gauges and summary quantiles
+
+Receive options:
+  -l, --listen ADDR          Accept remote-write requests on ` + "`ADDR`" + `, such as :9201
+
+Diffs:
+  ` + "`diff`" + ` compares the results of an instant query in one of two ways:
+  given ` + "`--time`" + ` twice, it compares the query at the first time to the query
+  at the second time; given ` + "`--target`" + ` twice, it compares the first target to
+  the second. Samples are matched up by their labels.
+
+Rendering saved results:
+  ` + "`render`" + ` shows a JSON result saved from the Prometheus API or ` + "`--json`" + `
+  without connecting to a target. ` + "`FILE`" + ` can be - to read standard input.
+
+Scraping:
+  ` + "`scrape`" + ` fetches the metrics exposed at ` + "`URL`" + ` in the Prometheus text format
+  and shows each metric family, using the same authentication options as
+  queries.
+
+Receiving:
+  ` + "`receive`" + ` accepts Prometheus remote-write requests (versions 1.0 and 2.0) at
+  any path on ` + "`ADDR`" + ` and shows the newest sample of each series sent so far,
+  redrawn as requests arrive. Only series matching ` + "`SELECTOR`" + `, such as
+  ` + "`{job=\"agent\"}`" + `, are shown if it is given.
+
+Saved queries:
+  Queries can be saved in the config file and run by name, with parameters in
+  NAME=VALUE form filling in ` + "`{{NAME}}`" + ` placeholders:
+
+    queries:
+      latency: histogram_quantile({{q}}, sum by (le) (rate(x_bucket{job="{{job}}"}[5m])))
+
+    quickprom @latency q=0.99 job=api
+
+Exit codes:
+  0  success
+  1  any other failure, including invalid queries
+  2  invalid command line or environment
+  3  authentication failure (401 or 403)
+  4  server error, or server could not be reached
+  5  timeout
+  6  query succeeded but returned no data
 
 Timestamp format:
   quickprom uses the excellent fuzzytime library, and thus supports a number of 
@@ -57,18 +180,72 @@ Timestamp format:
     - 2019-01-01T00:12:34Z
 `
 
+const (
+	FormatText        = "text"
+	FormatJson        = "json"
+	FormatOpenMetrics = "openmetrics"
+)
+
+const (
+	RangeLayoutAuto       = "auto"
+	RangeLayoutTable      = "table"
+	RangeLayoutTransposed = "transposed"
+	RangeLayoutList       = "list"
+)
+
 type QuickPromOptions struct {
-	Target        string `docopt:"--target" env:"QUICKPROM_TARGET"`
-	SkipTlsVerify bool   `docopt:"--skip-tls-verify" env:"QUICKPROM_SKIP_TLS_VERIFY"`
-	BasicAuth     string `docopt:"--basic-auth" env:"QUICKPROM_BASIC_AUTH"`
-	CfAuth        bool   `docopt:"--cf-auth" env:"QUICKPROM_CF_AUTH"`
-	Json          bool   `docopt:"--json" env:"QUICKPROM_JSON"`
-	RangeTable    bool   `docopt:"--range-table" env:"QUICKPROM_RANGE_TABLE"`
-	TimeoutInput  string `docopt:"--timeout" env:"QUICKPROM_TIMEOUT"`
+	Targets       []string `docopt:"--target" env:"QUICKPROM_TARGET"`
+	TsdbDir       string   `docopt:"--tsdb-dir" env:"QUICKPROM_TSDB_DIR"`
+	RemoteRead    string   `docopt:"--remote-read" env:"QUICKPROM_REMOTE_READ"`
+	SkipTlsVerify bool     `docopt:"--skip-tls-verify" env:"QUICKPROM_SKIP_TLS_VERIFY"`
+	BasicAuth     string   `docopt:"--basic-auth" env:"QUICKPROM_BASIC_AUTH"`
+	CfAuth        bool     `docopt:"--cf-auth" env:"QUICKPROM_CF_AUTH"`
+	Json          bool     `docopt:"--json" env:"QUICKPROM_JSON"`
+	Format        string   `docopt:"--format" env:"QUICKPROM_FORMAT"`
+	RangeLayout   string   `docopt:"--range-layout" env:"QUICKPROM_RANGE_LAYOUT"`
+	RangeTable    bool     `docopt:"--range-table" env:"QUICKPROM_RANGE_TABLE"`
+	QueryStats    bool     `docopt:"--query-stats" env:"QUICKPROM_QUERY_STATS"`
+	TimeoutInput  string   `docopt:"--timeout" env:"QUICKPROM_TIMEOUT"`
 	Timeout       time.Duration
+	RetriesInput  string `docopt:"--retries" env:"QUICKPROM_RETRIES"`
+	Retries       int
+	CacheInput    string `docopt:"--cache" env:"QUICKPROM_CACHE"`
+	Cache         time.Duration
+	NoCache       bool `docopt:"--no-cache"`
+
+	SeparateScales bool   `docopt:"--separate-scales" env:"QUICKPROM_SEPARATE_SCALES"`
+	PrecisionInput string `docopt:"--precision" env:"QUICKPROM_PRECISION"`
+	Precision      int
+	SigFigsInput   string `docopt:"--sig-figs" env:"QUICKPROM_SIG_FIGS"`
+	SigFigs        int
+
+	TimeInputs []string `docopt:"--time"`
+	Time       time.Time
+
+	RawBuckets bool `docopt:"--raw-buckets" env:"QUICKPROM_RAW_BUCKETS"`
 
-	TimeInput string `docopt:"--time"`
-	Time      time.Time
+	CompareOffsetsInput string `docopt:"--compare-offset"`
+	CompareOffsetNames  []string
+	CompareOffsets      []time.Duration
+
+	RenderEnabled bool   `docopt:"render"`
+	RenderFile    string `docopt:"FILE"`
+
+	ScrapeEnabled bool   `docopt:"scrape"`
+	ScrapeUrl     string `docopt:"URL"`
+	WatchInput    string `docopt:"--watch"`
+	Watch         time.Duration
+
+	ReceiveEnabled  bool   `docopt:"receive"`
+	ReceiveListen   string `docopt:"--listen"`
+	ReceiveSelector string `docopt:"SELECTOR"`
+	ReceiveMatchers []*labels.Matcher
+
+	LocalEnabled bool   `docopt:"local"`
+	LocalData    string `docopt:"--data"`
+
+	DiffEnabled bool `docopt:"diff"`
+	DiffTimes   []time.Time
 
 	RangeEnabled    bool   `docopt:"range"`
 	RangeStartInput string `docopt:"--start"`
@@ -77,13 +254,25 @@ type QuickPromOptions struct {
 	RangeEnd        time.Time
 	RangeStepInput  string `docopt:"--step"`
 	RangeStep       time.Duration
-
-	Query string `docopt:"QUERY"`
+	RangeSplitInput string `docopt:"--split" env:"QUICKPROM_SPLIT"`
+	RangeSplit      time.Duration
+	RangeStats      bool `docopt:"--stats" env:"QUICKPROM_STATS"`
+
+	ConfigPath string `docopt:"--config" env:"QUICKPROM_CONFIG"`
+	// Only loaded once a saved query is used
+	savedQueries *config.Config
+
+	Query            string   `docopt:"QUERY"`
+	QueryParams      []string `docopt:"PARAM"`
+	QueryFile        string   `docopt:"--file"`
+	ConcurrencyInput string   `docopt:"--concurrency" env:"QUICKPROM_CONCURRENCY"`
+	Concurrency      int
 }
 
 func ParseOptsAndEnv(exitOnError bool) (*QuickPromOptions, error) {
 	opts := QuickPromOptions{
-		Timeout: 5 * time.Second,
+		Timeout:     5 * time.Second,
+		Concurrency: 4,
 	}
 
 	err := envstruct.Load(&opts)
@@ -98,7 +287,27 @@ func ParseOptsAndEnv(exitOnError bool) (*QuickPromOptions, error) {
 
 	mergeOpts(&opts, cmdLineOpts)
 
-	if opts.Target == "" {
+	opts.Query, err = opts.ExpandQuery(opts.Query, opts.QueryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Targets = uniqueValues(opts.Targets)
+
+	if opts.RenderEnabled || opts.ScrapeEnabled || opts.ReceiveEnabled {
+		if opts.QueryFile != "" || opts.CompareOffsetsInput != "" {
+			return nil, errors.New("render, scrape and receive cannot be used with --file or --compare-offset")
+		}
+	} else if opts.TsdbDir != "" || opts.RemoteRead != "" {
+		if opts.LocalEnabled || (opts.TsdbDir != "" && opts.RemoteRead != "") {
+			return nil, errors.New("only one of local, --tsdb-dir and --remote-read can be used")
+		}
+
+		// These replace any targets, including one set in the environment
+		opts.Targets = nil
+	} else if opts.LocalEnabled {
+		// Queries are run against local data, so no target is needed
+	} else if len(opts.Targets) == 0 {
 		return nil, errors.New("must specify target URL with --target or QUICKPROM_TARGET")
 	}
 
@@ -110,6 +319,65 @@ func ParseOptsAndEnv(exitOnError bool) (*QuickPromOptions, error) {
 		}
 	}
 
+	switch opts.Format {
+	case "":
+		opts.Format = FormatText
+		if opts.Json {
+			opts.Format = FormatJson
+		}
+	case FormatText, FormatJson, FormatOpenMetrics:
+		if opts.Json && opts.Format != FormatJson {
+			return nil, fmt.Errorf("--json cannot be used with --format %s", opts.Format)
+		}
+	default:
+		return nil, fmt.Errorf("unknown --format %s, must be text, json or openmetrics", opts.Format)
+	}
+	opts.Json = opts.Format == FormatJson
+
+	switch opts.RangeLayout {
+	case "":
+		opts.RangeLayout = RangeLayoutAuto
+		if opts.RangeTable {
+			opts.RangeLayout = RangeLayoutTable
+		}
+	case RangeLayoutAuto, RangeLayoutTable, RangeLayoutTransposed, RangeLayoutList:
+		if opts.RangeTable && opts.RangeLayout != RangeLayoutTable {
+			return nil, fmt.Errorf("--range-table cannot be used with --range-layout %s", opts.RangeLayout)
+		}
+	default:
+		return nil, fmt.Errorf("unknown --range-layout %s, must be auto, table, transposed or list", opts.RangeLayout)
+	}
+
+	if opts.Format == FormatOpenMetrics && (opts.DiffEnabled || opts.QueryFile != "" || opts.CompareOffsetsInput != "") {
+		return nil, errors.New("--format openmetrics can only be used with a single query")
+	}
+
+	if opts.WatchInput != "" {
+		if !opts.ScrapeEnabled || opts.Format != FormatText {
+			return nil, errors.New("--watch can only be used with scrape and text output")
+		}
+
+		parsedWatch, err := model.ParseDuration(opts.WatchInput)
+		if err != nil || parsedWatch <= 0 {
+			return nil, errors.New("--watch must be a positive duration")
+		}
+
+		opts.Watch = time.Duration(parsedWatch)
+	}
+
+	if opts.ReceiveEnabled {
+		if opts.Format != FormatText {
+			return nil, errors.New("receive can only be used with text output")
+		}
+
+		if opts.ReceiveSelector != "" {
+			opts.ReceiveMatchers, err = parser.NewParser(parser.Options{}).ParseMetricSelector(opts.ReceiveSelector)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse selector: %s", err)
+			}
+		}
+	}
+
 	if opts.TimeoutInput != "" {
 		opts.Timeout, err = time.ParseDuration(opts.TimeoutInput)
 
@@ -118,6 +386,51 @@ func ParseOptsAndEnv(exitOnError bool) (*QuickPromOptions, error) {
 		}
 	}
 
+	if opts.RetriesInput != "" {
+		opts.Retries, err = strconv.Atoi(opts.RetriesInput)
+
+		if err != nil || opts.Retries < 0 {
+			return nil, errors.New("--retries must be a non-negative integer")
+		}
+	}
+
+	if opts.CacheInput != "" && !opts.NoCache {
+		parsedCache, err := model.ParseDuration(opts.CacheInput)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse --cache: %s", err)
+		}
+
+		opts.Cache = time.Duration(parsedCache)
+	}
+
+	if opts.ConcurrencyInput != "" {
+		opts.Concurrency, err = strconv.Atoi(opts.ConcurrencyInput)
+
+		if err != nil || opts.Concurrency < 1 {
+			return nil, errors.New("--concurrency must be a positive integer")
+		}
+	}
+
+	if opts.PrecisionInput != "" && opts.SigFigsInput != "" {
+		return nil, errors.New("only one of --precision and --sig-figs can be used")
+	}
+
+	if opts.PrecisionInput != "" {
+		opts.Precision, err = strconv.Atoi(opts.PrecisionInput)
+
+		if err != nil || opts.Precision < 0 {
+			return nil, errors.New("--precision must be a non-negative integer")
+		}
+	}
+
+	if opts.SigFigsInput != "" {
+		opts.SigFigs, err = strconv.Atoi(opts.SigFigsInput)
+
+		if err != nil || opts.SigFigs < 1 {
+			return nil, errors.New("--sig-figs must be a positive integer")
+		}
+	}
+
 	if opts.RangeEnabled {
 		opts.RangeStart, err = ParseTime(opts.RangeStartInput)
 		if err != nil {
@@ -143,25 +456,133 @@ func ParseOptsAndEnv(exitOnError bool) (*QuickPromOptions, error) {
 		}
 
 		opts.RangeStep = time.Duration(parsedStep)
+
+		if opts.RangeSplitInput != "" {
+			parsedSplit, err := model.ParseDuration(opts.RangeSplitInput)
+			if err != nil || parsedSplit == 0 {
+				return nil, errors.New("--split must be a positive duration")
+			}
+
+			opts.RangeSplit = time.Duration(parsedSplit)
+		}
+
+		if opts.CompareOffsetsInput != "" {
+			return nil, errors.New("--compare-offset can only be used with instant queries")
+		}
 	} else {
-		if opts.TimeInput == "" {
-			opts.Time = time.Now()
-		} else {
-			opts.Time, err = ParseTime(opts.TimeInput)
+		var times []time.Time
+		for _, timeInput := range uniqueValues(opts.TimeInputs) {
+			parsedTime, err := ParseTime(timeInput)
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse --time: %s", err)
 			}
+
+			times = append(times, parsedTime)
+		}
+
+		if len(times) == 0 {
+			opts.Time = time.Now()
+		} else {
+			opts.Time = times[0]
+		}
+
+		if opts.CompareOffsetsInput != "" {
+			if opts.DiffEnabled || opts.QueryFile != "" {
+				return nil, errors.New("--compare-offset can only be used with a single instant query")
+			}
+
+			for _, offsetInput := range strings.Split(opts.CompareOffsetsInput, ",") {
+				offsetInput = strings.TrimSpace(offsetInput)
+
+				offset, err := model.ParseDuration(offsetInput)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse --compare-offset: %s", err)
+				}
+
+				opts.CompareOffsetNames = append(opts.CompareOffsetNames, offsetInput)
+				opts.CompareOffsets = append(opts.CompareOffsets, time.Duration(offset))
+			}
+		}
+
+		if opts.DiffEnabled {
+			if len(times) == 2 {
+				opts.DiffTimes = times
+			} else if len(times) > 2 || len(opts.Targets) != 2 {
+				return nil, errors.New("diff needs either two --time options or two --target options")
+			}
 		}
 	}
 
 	return &opts, nil
 }
 
+func uniqueValues(values []string) (result []string) {
+	// docopt repeats some values of options given more than once, so duplicates are dropped (which
+	// also means a target can't accidentally be queried twice)
+	seenValues := make(map[string]struct{})
+
+	for _, value := range values {
+		if _, seen := seenValues[value]; seen || value == "" {
+			continue
+		}
+
+		seenValues[value] = struct{}{}
+		result = append(result, value)
+	}
+
+	return
+}
+
+// ExpandQuery expands a saved query like config.Config.Expand. The config file is only loaded the
+// first time a saved query is used, so a broken config file doesn't stop other queries from running.
+func (opts *QuickPromOptions) ExpandQuery(query string, params []string) (string, error) {
+	if !strings.HasPrefix(query, "@") {
+		return (&config.Config{}).Expand(query, params)
+	}
+
+	if opts.savedQueries == nil {
+		var err error
+		opts.savedQueries, err = loadConfig(opts.ConfigPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load config: %s", err)
+		}
+	}
+
+	return opts.savedQueries.Expand(query, params)
+}
+
+func loadConfig(path string) (*config.Config, error) {
+	if path != "" {
+		return config.Load(path)
+	}
+
+	// The default config file is optional
+	defaultPath, err := config.DefaultPath()
+	if err != nil {
+		return &config.Config{}, nil
+	}
+
+	loadedConfig, err := config.Load(defaultPath)
+	if os.IsNotExist(err) {
+		return &config.Config{}, nil
+	}
+
+	return loadedConfig, err
+}
+
 func parseCmdLineOpts(exitOnError bool) (*QuickPromOptions, error) {
 	var helpHandler func(error, string)
 	var cmdlineUsageErr error
 	if exitOnError {
-		helpHandler = docopt.PrintHelpAndExit
+		helpHandler = func(err error, usage string) {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, usage)
+				os.Exit(exitcode.Usage)
+			}
+
+			fmt.Println(usage)
+			os.Exit(exitcode.Success)
+		}
 	} else {
 		helpHandler = func(err error, usage string) {
 			cmdlineUsageErr = errors.New(usage)
@@ -197,8 +618,16 @@ func mergeOpts(destOpts, srcOpts *QuickPromOptions) {
 		destFieldVal := destOptsVal.Field(i)
 		srcFieldVal := srcOptsVal.Field(i)
 
+		if !destFieldVal.CanSet() {
+			continue
+		}
+
 		zeroVal := reflect.Zero(destFieldVal.Type()).Interface()
 
+		if srcFieldVal.Kind() == reflect.Slice && srcFieldVal.Len() == 0 {
+			continue
+		}
+
 		if !reflect.DeepEqual(srcFieldVal.Interface(), zeroVal) {
 			destFieldVal.Set(srcFieldVal)
 		}
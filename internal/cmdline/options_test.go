@@ -2,6 +2,7 @@ package cmdline_test
 
 import (
 	"os"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -11,6 +12,9 @@ import (
 	"github.com/pianohacker/quickprom/internal/cmdline"
 )
 
+// Config directories have to be absolute
+var brokenConfigHome, _ = filepath.Abs("testdata/broken")
+
 var _ = Describe("Options", func() {
 	DescribeTable("ParseOptsAndEnv",
 		func(
@@ -38,7 +42,7 @@ var _ = Describe("Options", func() {
 			func(opts *cmdline.QuickPromOptions, err error) {
 				Expect(err).ToNot(HaveOccurred())
 
-				Expect(opts.Target).To(Equal("target"))
+				Expect(opts.Targets).To(Equal([]string{"target"}))
 				Expect(opts.Query).To(Equal("query"))
 			},
 		),
@@ -52,7 +56,7 @@ var _ = Describe("Options", func() {
 			func(opts *cmdline.QuickPromOptions, err error) {
 				Expect(err).ToNot(HaveOccurred())
 
-				Expect(opts.Target).To(Equal("cmdline_target"))
+				Expect(opts.Targets).To(Equal([]string{"cmdline_target"}))
 				Expect(opts.Query).To(Equal("query"))
 			},
 		),
@@ -153,6 +157,70 @@ var _ = Describe("Options", func() {
 			},
 		),
 
+		Entry("can parse --format from command line",
+			[]string{"quickprom", "-t", "target", "--format", "openmetrics", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Format).To(Equal(cmdline.FormatOpenMetrics))
+				Expect(opts.Json).To(BeFalse())
+			},
+		),
+
+		Entry("can parse --format from environment variable",
+			[]string{"quickprom", "-t", "target", "query"},
+			map[string]string{
+				"QUICKPROM_FORMAT": "json",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Format).To(Equal(cmdline.FormatJson))
+				Expect(opts.Json).To(BeTrue())
+			},
+		),
+
+		Entry("defaults --format to text, or json when --json is given",
+			[]string{"quickprom", "-t", "target", "--json", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Format).To(Equal(cmdline.FormatJson))
+			},
+		),
+
+		Entry("returns an error when --format is unknown",
+			[]string{"quickprom", "-t", "target", "--format", "xml", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("unknown --format xml")))
+			},
+		),
+
+		Entry("returns an error when --json conflicts with --format",
+			[]string{"quickprom", "-t", "target", "--json", "--format", "openmetrics", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
+		Entry("returns an error when --format openmetrics is used with --file",
+			[]string{"quickprom", "-t", "target", "--format", "openmetrics", "--file", "queries.yml"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("single query")))
+			},
+		),
+
 		Entry("can parse --range-table from command line",
 			[]string{
 				"quickprom",
@@ -167,6 +235,7 @@ var _ = Describe("Options", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				Expect(opts.RangeTable).To(BeTrue())
+				Expect(opts.RangeLayout).To(Equal(cmdline.RangeLayoutTable))
 			},
 		),
 
@@ -187,6 +256,48 @@ var _ = Describe("Options", func() {
 			},
 		),
 
+		Entry("defaults --range-layout to auto",
+			[]string{"quickprom", "-t", "target", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RangeLayout).To(Equal(cmdline.RangeLayoutAuto))
+			},
+		),
+
+		Entry("can parse --range-layout from environment variable",
+			[]string{"quickprom", "-t", "target", "query"},
+			map[string]string{
+				"QUICKPROM_RANGE_LAYOUT": "transposed",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RangeLayout).To(Equal(cmdline.RangeLayoutTransposed))
+			},
+		),
+
+		Entry("returns an error for an unknown --range-layout",
+			[]string{"quickprom", "-t", "target", "--range-layout", "grid", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError("unknown --range-layout grid, must be auto, table, transposed or list"))
+			},
+		),
+
+		Entry("returns an error when --range-table conflicts with --range-layout",
+			[]string{"quickprom", "-t", "target", "-b", "--range-layout", "list", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError("--range-table cannot be used with --range-layout list"))
+			},
+		),
+
 		Entry("can parse --range-table from environment variable",
 			[]string{
 				"quickprom",
@@ -405,6 +516,664 @@ var _ = Describe("Options", func() {
 			},
 		),
 
+		Entry("can parse --file in place of a query",
+			[]string{"quickprom", "-t", "target", "--file", "queries.yml"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.QueryFile).To(Equal("queries.yml"))
+				Expect(opts.Query).To(BeEmpty())
+			},
+		),
+
+		Entry("can parse --file for range queries",
+			[]string{
+				"quickprom",
+				"range",
+				"-f",
+				"queries.yml",
+				"--start",
+				"2018-01-02 00:12:45.000 UTC",
+				"--step",
+				"1d",
+			},
+			map[string]string{
+				"QUICKPROM_TARGET": "target",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RangeEnabled).To(BeTrue())
+				Expect(opts.QueryFile).To(Equal("queries.yml"))
+			},
+		),
+
+		Entry("defaults --concurrency to 4 when not provided",
+			[]string{"quickprom", "-t", "target", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Concurrency).To(Equal(4))
+			},
+		),
+
+		Entry("can parse --concurrency from environment variable",
+			[]string{"quickprom", "-t", "target", "-f", "queries.yml"},
+			map[string]string{
+				"QUICKPROM_CONCURRENCY": "8",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Concurrency).To(Equal(8))
+			},
+		),
+
+		Entry("returns an error when concurrency is invalid",
+			[]string{"quickprom", "-t", "target", "--concurrency", "0", "-f", "queries.yml"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
+		Entry("can parse --separate-scales and --precision",
+			[]string{"quickprom", "-t", "target", "--separate-scales", "--precision", "2", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.SeparateScales).To(BeTrue())
+				Expect(opts.Precision).To(Equal(2))
+			},
+		),
+
+		Entry("can parse --sig-figs from environment variable",
+			[]string{"quickprom", "-t", "target", "query"},
+			map[string]string{
+				"QUICKPROM_SIG_FIGS": "3",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.SigFigs).To(Equal(3))
+			},
+		),
+
+		Entry("returns an error when --sig-figs is invalid",
+			[]string{"quickprom", "-t", "target", "--sig-figs", "0", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError("--sig-figs must be a positive integer"))
+			},
+		),
+
+		Entry("returns an error when both --precision and --sig-figs are given",
+			[]string{"quickprom", "-t", "target", "--precision", "2", "--sig-figs", "3", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError("only one of --precision and --sig-figs can be used"))
+			},
+		),
+
+		Entry("expands saved queries from --config",
+			[]string{"quickprom", "-t", "target", "--config", "testdata/config.yml", "@latency", "q=0.99", "job=api"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Query).To(Equal(`histogram_quantile(0.99, sum by (le) (rate(x_bucket{job="api"}[5m])))`))
+			},
+		),
+
+		Entry("can parse --config from environment variable",
+			[]string{"quickprom", "-t", "target", "@up"},
+			map[string]string{
+				"QUICKPROM_CONFIG": "testdata/config.yml",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Query).To(Equal("up"))
+			},
+		),
+
+		Entry("returns an error when the given config doesn't exist",
+			[]string{"quickprom", "-t", "target", "--config", "testdata/potato.yml", "@up"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
+		Entry("only loads the default config for saved queries",
+			[]string{"quickprom", "-t", "target", "query"},
+			map[string]string{
+				"XDG_CONFIG_HOME": brokenConfigHome,
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Query).To(Equal("query"))
+			},
+		),
+
+		Entry("returns an error when the default config is broken and a saved query is used",
+			[]string{"quickprom", "-t", "target", "@up"},
+			map[string]string{
+				"XDG_CONFIG_HOME": brokenConfigHome,
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("failed to load config")))
+			},
+		),
+
+		Entry("returns an error when a saved query doesn't exist",
+			[]string{"quickprom", "-t", "target", "--config", "testdata/config.yml", "@potato"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
+		Entry("can parse more than one --target from command line",
+			[]string{"quickprom", "-t", "target-a", "--target", "target-b", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Targets).To(Equal([]string{"target-a", "target-b"}))
+			},
+		),
+
+		Entry("can parse more than one --target for range queries",
+			[]string{
+				"quickprom",
+				"range",
+				"-t",
+				"target-a",
+				"--start",
+				"2018-01-02 00:12:45.000 UTC",
+				"--step",
+				"1d",
+				"-t",
+				"target-b",
+				"-t",
+				"target-c",
+				"query",
+			},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Targets).To(Equal([]string{"target-a", "target-b", "target-c"}))
+			},
+		),
+
+		Entry("can parse more than one target from environment variable",
+			[]string{"quickprom", "query"},
+			map[string]string{
+				"QUICKPROM_TARGET": "target-a,target-b",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Targets).To(Equal([]string{"target-a", "target-b"}))
+			},
+		),
+
+		Entry("can parse two times for diff",
+			[]string{
+				"quickprom",
+				"-t",
+				"target",
+				"diff",
+				"query",
+				"--time",
+				"2018-01-02 00:12:45.000 UTC",
+				"-i",
+				"2018-01-03 00:12:45.000 UTC",
+			},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.DiffEnabled).To(BeTrue())
+				Expect(opts.Query).To(Equal("query"))
+				Expect(opts.DiffTimes).To(HaveLen(2))
+				Expect(opts.DiffTimes[0]).To(BeTemporally("~", time.Date(
+					2018, 1, 2,
+					0, 12, 45,
+					0,
+					time.UTC,
+				)))
+				Expect(opts.DiffTimes[1]).To(BeTemporally("~", time.Date(
+					2018, 1, 3,
+					0, 12, 45,
+					0,
+					time.UTC,
+				)))
+			},
+		),
+
+		Entry("can diff two targets",
+			[]string{"quickprom", "-t", "target-a", "-t", "target-b", "diff", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.DiffEnabled).To(BeTrue())
+				Expect(opts.DiffTimes).To(BeNil())
+				Expect(opts.Time).To(BeTemporally("~", time.Now()))
+			},
+		),
+
+		Entry("returns an error when diff has only one time and target",
+			[]string{"quickprom", "-t", "target", "diff", "query", "--time", "2018-01-02"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
+		Entry("can parse --compare-offset",
+			[]string{"quickprom", "-t", "target", "--compare-offset", "1d, 1w", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.CompareOffsetNames).To(Equal([]string{"1d", "1w"}))
+				Expect(opts.CompareOffsets).To(Equal([]time.Duration{
+					24 * time.Hour,
+					7 * 24 * time.Hour,
+				}))
+			},
+		),
+
+		Entry("returns an error when --compare-offset is invalid",
+			[]string{"quickprom", "-t", "target", "--compare-offset", "1d,potato", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
+		Entry("returns an error when --compare-offset is used with a range query",
+			[]string{
+				"quickprom",
+				"range",
+				"-t",
+				"target",
+				"--start",
+				"2018-01-02",
+				"--step",
+				"1d",
+				"--compare-offset",
+				"1d",
+				"query",
+			},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
+		Entry("can parse --stats from command line",
+			[]string{
+				"quickprom",
+				"range",
+				"--start",
+				"2018-01-02",
+				"--step",
+				"1d",
+				"--stats",
+				"query",
+			},
+			map[string]string{
+				"QUICKPROM_TARGET": "target",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RangeStats).To(BeTrue())
+			},
+		),
+
+		Entry("can parse --stats from environment variable",
+			[]string{"quickprom", "query"},
+			map[string]string{
+				"QUICKPROM_TARGET": "target",
+				"QUICKPROM_STATS":  "true",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RangeStats).To(BeTrue())
+			},
+		),
+
+		Entry("can parse --raw-buckets from command line",
+			[]string{"quickprom", "-t", "target", "--raw-buckets", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RawBuckets).To(BeTrue())
+			},
+		),
+
+		Entry("can parse render without a target",
+			[]string{"quickprom", "render", "result.json"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RenderEnabled).To(BeTrue())
+				Expect(opts.RenderFile).To(Equal("result.json"))
+				Expect(opts.Query).To(BeEmpty())
+			},
+		),
+
+		Entry("can render from standard input",
+			[]string{"quickprom", "--range-table", "render", "-"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RenderFile).To(Equal("-"))
+				Expect(opts.RangeTable).To(BeTrue())
+			},
+		),
+
+		Entry("can parse scrape without a target",
+			[]string{"quickprom", "--cf-auth", "scrape", "http://exporter:9100/metrics"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.ScrapeEnabled).To(BeTrue())
+				Expect(opts.ScrapeUrl).To(Equal("http://exporter:9100/metrics"))
+				Expect(opts.CfAuth).To(BeTrue())
+			},
+		),
+
+		Entry("can parse --watch for scrape",
+			[]string{"quickprom", "scrape", "http://exporter:9100/metrics", "--watch", "5s"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Watch).To(Equal(5 * time.Second))
+			},
+		),
+
+		Entry("returns an error when --watch is used without scrape",
+			[]string{"quickprom", "-t", "target", "--watch", "5s", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("--watch")))
+			},
+		),
+
+		Entry("returns an error when --watch is invalid",
+			[]string{"quickprom", "-w", "soon", "scrape", "http://exporter:9100/metrics"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("positive duration")))
+			},
+		),
+
+		Entry("can parse local without a target",
+			[]string{"quickprom", "local", "--data", "data.om", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.LocalEnabled).To(BeTrue())
+				Expect(opts.LocalData).To(Equal("data.om"))
+				Expect(opts.Query).To(Equal("query"))
+			},
+		),
+
+		Entry("can parse local range queries",
+			[]string{"quickprom", "local", "-d", "data.om", "range", "query", "--start", "2018-01-01", "--step", "1m"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.LocalEnabled).To(BeTrue())
+				Expect(opts.RangeEnabled).To(BeTrue())
+				Expect(opts.RangeStep).To(Equal(time.Minute))
+			},
+		),
+
+		Entry("can parse --split",
+			[]string{"quickprom", "-t", "target", "range", "query", "--start", "2018-01-01", "--step", "5m", "--split", "7d"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RangeSplit).To(Equal(7 * 24 * time.Hour))
+			},
+		),
+
+		Entry("returns an error when --split is zero",
+			[]string{"quickprom", "-t", "target", "range", "query", "--start", "2018-01-01", "--step", "5m", "--split", "0s"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("--split")))
+			},
+		),
+
+		Entry("can parse --cache",
+			[]string{"quickprom", "-t", "target", "--cache", "1d", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Cache).To(Equal(24 * time.Hour))
+			},
+		),
+
+		Entry("ignores QUICKPROM_CACHE with --no-cache",
+			[]string{"quickprom", "-t", "target", "--no-cache", "query"},
+			map[string]string{
+				"QUICKPROM_CACHE": "1h",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Cache).To(BeZero())
+			},
+		),
+
+		Entry("returns an error when --cache is invalid",
+			[]string{"quickprom", "-t", "target", "--cache", "potato", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("--cache")))
+			},
+		),
+
+		Entry("can parse --retries",
+			[]string{"quickprom", "-t", "target", "--retries", "3", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.Retries).To(Equal(3))
+			},
+		),
+
+		Entry("returns an error when --retries is negative",
+			[]string{"quickprom", "-t", "target", "--retries", "-1", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("--retries")))
+			},
+		),
+
+		Entry("can parse --query-stats from environment variable",
+			[]string{"quickprom", "-t", "target", "query"},
+			map[string]string{
+				"QUICKPROM_QUERY_STATS": "true",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.QueryStats).To(BeTrue())
+				Expect(opts.RangeStats).To(BeFalse())
+			},
+		),
+
+		Entry("can parse --tsdb-dir instead of a target",
+			[]string{"quickprom", "--tsdb-dir", "/prometheus", "query"},
+			map[string]string{
+				"QUICKPROM_TARGET": "target",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.TsdbDir).To(Equal("/prometheus"))
+				Expect(opts.Targets).To(BeEmpty())
+			},
+		),
+
+		Entry("can parse --tsdb-dir from environment variable",
+			[]string{"quickprom", "range", "query", "--start", "2018-01-01", "--step", "1m"},
+			map[string]string{
+				"QUICKPROM_TSDB_DIR": "/prometheus",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.TsdbDir).To(Equal("/prometheus"))
+				Expect(opts.RangeEnabled).To(BeTrue())
+			},
+		),
+
+		Entry("returns an error when --tsdb-dir is used with local",
+			[]string{"quickprom", "--tsdb-dir", "/prometheus", "local", "--data", "data.om", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("--tsdb-dir")))
+			},
+		),
+
+		Entry("can parse --remote-read instead of a target",
+			[]string{"quickprom", "--remote-read", "http://storage/api/v1/read", "query"},
+			map[string]string{
+				"QUICKPROM_TARGET": "target",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.RemoteRead).To(Equal("http://storage/api/v1/read"))
+				Expect(opts.Targets).To(BeEmpty())
+			},
+		),
+
+		Entry("returns an error when --remote-read is used with --tsdb-dir",
+			[]string{"quickprom", "--remote-read", "http://storage/api/v1/read", "--tsdb-dir", "/prometheus", "query"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("--remote-read")))
+			},
+		),
+
+		Entry("can parse receive without a target",
+			[]string{"quickprom", "receive", "--listen", ":9201", `{job="agent"}`},
+			map[string]string{
+				"QUICKPROM_TARGET": "",
+			},
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(opts.ReceiveEnabled).To(BeTrue())
+				Expect(opts.ReceiveListen).To(Equal(":9201"))
+				Expect(opts.ReceiveMatchers).To(HaveLen(1))
+				Expect(opts.ReceiveMatchers[0].String()).To(Equal(`job="agent"`))
+			},
+		),
+
+		Entry("returns an error when the receive selector is invalid",
+			[]string{"quickprom", "receive", "-l", ":9201", `{job=}`},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("selector")))
+			},
+		),
+
+		Entry("returns an error when receive is used with --json",
+			[]string{"quickprom", "--json", "receive", "-l", ":9201"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(MatchError(ContainSubstring("text output")))
+			},
+		),
+
+		Entry("returns an error when render is used with --file",
+			[]string{"quickprom", "--file", "queries.yml", "render", "-"},
+			nil,
+
+			func(opts *cmdline.QuickPromOptions, err error) {
+				Expect(err).To(HaveOccurred())
+			},
+		),
+
 		Entry("returns an error when target is unspecified",
 			[]string{"quickprom", "query"},
 			map[string]string{
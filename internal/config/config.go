@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	Queries map[string]string `yaml:"queries"`
+}
+
+// DefaultPath returns the location of the config file used when none is given,
+// `quickprom/config.yml` in the platform's config directory, such as
+// `~/.config/quickprom/config.yml` on Linux.
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "quickprom", "config.yml"), nil
+}
+
+func Load(path string) (*Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(contents)
+}
+
+func Parse(contents []byte) (*Config, error) {
+	var config Config
+	err := yaml.UnmarshalStrict(contents, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+var paramMatcher = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+
+// Expand replaces a saved query reference like `@latency` with the saved query, filling in its
+// `{{param}}` placeholders from `params`, each of which must be in `NAME=VALUE` form. Queries that
+// don't start with `@` are returned unchanged.
+func (c *Config) Expand(query string, params []string) (string, error) {
+	if !strings.HasPrefix(query, "@") {
+		if len(params) != 0 {
+			return "", errors.New("parameters can only be given to saved queries")
+		}
+
+		return query, nil
+	}
+
+	name := query[1:]
+	savedQuery, ok := c.Queries[name]
+	if !ok {
+		return "", fmt.Errorf("no saved query named %s", name)
+	}
+
+	paramValues := make(map[string]string)
+	for _, param := range params {
+		paramParts := strings.SplitN(param, "=", 2)
+		if len(paramParts) != 2 {
+			return "", fmt.Errorf("parameter %s must be in NAME=VALUE format", param)
+		}
+
+		paramValues[paramParts[0]] = paramParts[1]
+	}
+
+	usedParams := make(map[string]struct{})
+	missingParams := make(map[string]struct{})
+	expanded := paramMatcher.ReplaceAllStringFunc(savedQuery, func(placeholder string) string {
+		paramName := paramMatcher.FindStringSubmatch(placeholder)[1]
+
+		value, ok := paramValues[paramName]
+		if !ok {
+			missingParams[paramName] = struct{}{}
+			return placeholder
+		}
+
+		usedParams[paramName] = struct{}{}
+		return value
+	})
+
+	if len(missingParams) != 0 {
+		return "", fmt.Errorf("saved query %s is missing parameters: %s", name, sortedNames(missingParams))
+	}
+
+	unusedParams := make(map[string]struct{})
+	for paramName, _ := range paramValues {
+		if _, ok := usedParams[paramName]; !ok {
+			unusedParams[paramName] = struct{}{}
+		}
+	}
+
+	if len(unusedParams) != 0 {
+		return "", fmt.Errorf("saved query %s has no parameters: %s", name, sortedNames(unusedParams))
+	}
+
+	return expanded, nil
+}
+
+func sortedNames(nameSet map[string]struct{}) string {
+	var names []string
+	for name, _ := range nameSet {
+		names = append(names, name)
+	}
+	sort.Sort(sort.StringSlice(names))
+
+	return strings.Join(names, ", ")
+}
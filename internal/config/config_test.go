@@ -0,0 +1,75 @@
+package config_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/config"
+)
+
+var _ = Describe("Config", func() {
+	Describe("Parse()", func() {
+		It("can parse saved queries", func() {
+			parsedConfig, err := config.Parse([]byte(`
+queries:
+  up: up{job="node"}
+`))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsedConfig.Queries).To(Equal(map[string]string{
+				"up": `up{job="node"}`,
+			}))
+		})
+
+		It("returns an error for unknown keys", func() {
+			_, err := config.Parse([]byte("potato: true\n"))
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Expand()", func() {
+		testConfig := &config.Config{
+			Queries: map[string]string{
+				"up": "up",
+				"latency": `histogram_quantile({{q}}, sum by (le) ` +
+					`(rate(http_request_duration_seconds_bucket{job="{{ job }}"}[5m])))`,
+				"repeated": `{{job}}_a + {{job}}_b`,
+			},
+		}
+
+		DescribeTable("expands saved queries",
+			func(query string, params []string, expected string) {
+				expanded, err := testConfig.Expand(query, params)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(expanded).To(Equal(expected))
+			},
+
+			Entry("leaves plain queries alone", "up{job=\"a\"}", nil, "up{job=\"a\"}"),
+			Entry("expands saved queries without parameters", "@up", nil, "up"),
+			Entry("fills in parameters",
+				"@latency",
+				[]string{"q=0.99", "job=api"},
+				`histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket{job="api"}[5m])))`,
+			),
+			Entry("fills in repeated parameters", "@repeated", []string{"job=x"}, "x_a + x_b"),
+			Entry("allows = in parameter values", "@repeated", []string{"job=a=b"}, "a=b_a + a=b_b"),
+		)
+
+		DescribeTable("returns errors",
+			func(query string, params []string) {
+				_, err := testConfig.Expand(query, params)
+
+				Expect(err).To(HaveOccurred())
+			},
+
+			Entry("for unknown saved queries", "@potato", nil),
+			Entry("for parameters to plain queries", "up", []string{"job=api"}),
+			Entry("for malformed parameters", "@repeated", []string{"job"}),
+			Entry("for missing parameters", "@latency", []string{"q=0.99"}),
+			Entry("for unused parameters", "@up", []string{"job=api"}),
+		)
+	})
+})
@@ -0,0 +1,63 @@
+package exitcode
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Exit codes, so scripts can tell why quickprom failed.
+const (
+	Success = 0
+	// Failure covers anything without a more specific code, including invalid queries
+	Failure = 1
+	Usage   = 2
+	Auth    = 3
+	// Server covers server errors and servers that can't be reached
+	Server  = 4
+	Timeout = 5
+	// Empty is used when a query succeeds, but returns no data
+	Empty = 6
+)
+
+// ForError picks the exit code for an error returned by a query.
+func ForError(err error) int {
+	if err == nil {
+		return Success
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout
+	}
+
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case v1.ErrTimeout:
+			return Timeout
+		case v1.ErrServer, v1.ErrBadResponse:
+			return Server
+		case v1.ErrClient:
+			// The client only gives the status code in the message
+			if strings.HasSuffix(apiErr.Msg, ": 401") || strings.HasSuffix(apiErr.Msg, ": 403") {
+				return Auth
+			}
+		}
+
+		return Failure
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return Timeout
+		}
+
+		return Server
+	}
+
+	return Failure
+}
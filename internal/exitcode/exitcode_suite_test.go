@@ -0,0 +1,13 @@
+package exitcode_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestExitcode(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Exitcode Suite")
+}
@@ -0,0 +1,40 @@
+package exitcode_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/exitcode"
+)
+
+var _ = Describe("ForError()", func() {
+	DescribeTable("picks an exit code",
+		func(err error, code int) {
+			Expect(exitcode.ForError(err)).To(Equal(code))
+		},
+
+		Entry("for no error", nil, exitcode.Success),
+		Entry("for unauthorized responses", &v1.Error{Type: v1.ErrClient, Msg: "client error: 401"}, exitcode.Auth),
+		Entry("for forbidden responses", &v1.Error{Type: v1.ErrClient, Msg: "client error: 403"}, exitcode.Auth),
+		Entry("for other client errors", &v1.Error{Type: v1.ErrClient, Msg: "client error: 404"}, exitcode.Failure),
+		Entry("for server errors", &v1.Error{Type: v1.ErrServer, Msg: "server error: 502"}, exitcode.Server),
+		Entry("for invalid queries", &v1.Error{Type: v1.ErrBadData, Msg: "parse error"}, exitcode.Failure),
+		Entry("for query timeouts", &v1.Error{Type: v1.ErrTimeout, Msg: "query timed out"}, exitcode.Timeout),
+		Entry("for deadlines", &url.Error{Op: "Post", URL: "http://a", Err: context.DeadlineExceeded}, exitcode.Timeout),
+		Entry("for unreachable servers",
+			&url.Error{Op: "Post", URL: "http://a", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+			exitcode.Server,
+		),
+		Entry("for wrapped errors", fmt.Errorf("target: %w", &v1.Error{Type: v1.ErrServer}), exitcode.Server),
+		Entry("for anything else", errors.New("no such file"), exitcode.Failure),
+	)
+})
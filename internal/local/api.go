@@ -0,0 +1,126 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/stats"
+
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+// API runs queries with the PromQL engine against local storage, instead of sending them to a
+// server.
+type API struct {
+	queryable storage.Queryable
+	engine    *promql.Engine
+}
+
+func NewAPI(queryable storage.Queryable) *API {
+	return &API{
+		queryable: queryable,
+		engine: promql.NewEngine(promql.EngineOpts{
+			MaxSamples:           50000000,
+			Timeout:              time.Hour,
+			EnableAtModifier:     true,
+			EnableNegativeOffset: true,
+		}),
+	}
+}
+
+func (a *API) Query(ctx context.Context, query string, ts time.Time, _ ...v1.Option) (model.Value, v1.Warnings, error) {
+	q, err := a.engine.NewInstantQuery(ctx, a.queryable, nil, query, ts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return runQuery(ctx, q, query)
+}
+
+func (a *API) QueryRange(ctx context.Context, query string, r v1.Range, _ ...v1.Option) (model.Value, v1.Warnings, error) {
+	q, err := a.engine.NewRangeQuery(ctx, a.queryable, nil, query, r.Start, r.End, r.Step)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return runQuery(ctx, q, query)
+}
+
+func runQuery(ctx context.Context, q promql.Query, queryString string) (model.Value, v1.Warnings, error) {
+	defer q.Close()
+
+	result := q.Exec(ctx)
+
+	if queryStats := query.StatsFrom(ctx); queryStats != nil {
+		err := convertJson(stats.NewQueryStats(q.Stats()).Builtin(), queryStats)
+		queryStats.Found = err == nil
+	}
+
+	warnings, infos := result.Warnings.AsStrings(queryString, 0, 0)
+	warnings = append(warnings, infos...)
+	if result.Err != nil {
+		return nil, warnings, result.Err
+	}
+
+	value, err := convertValue(result.Value)
+
+	return value, warnings, err
+}
+
+type jsonSeries struct {
+	Metric     labels.Labels   `json:"metric"`
+	Values     []promql.FPoint `json:"values,omitempty"`
+	Histograms []promql.HPoint `json:"histograms,omitempty"`
+}
+
+// convertValue turns a PromQL result into the same value the HTTP API would return. Vectors and
+// matrices go through the same JSON as the HTTP API, so native histograms come out identically.
+func convertValue(value interface{}) (model.Value, error) {
+	switch v := value.(type) {
+	case promql.Scalar:
+		return &model.Scalar{
+			Value:     model.SampleValue(v.V),
+			Timestamp: model.Time(v.T),
+		}, nil
+	case promql.String:
+		return &model.String{
+			Value:     v.V,
+			Timestamp: model.Time(v.T),
+		}, nil
+	case promql.Vector:
+		var vector model.Vector
+		err := convertJson(v, &vector)
+		return vector, err
+	case promql.Matrix:
+		var series []jsonSeries
+		for _, s := range v {
+			series = append(series, jsonSeries{
+				Metric:     s.Metric,
+				Values:     s.Floats,
+				Histograms: s.Histograms,
+			})
+		}
+
+		matrix := model.Matrix{}
+		err := convertJson(series, &matrix)
+		return matrix, err
+	}
+
+	return nil, fmt.Errorf("unknown result type %T", value)
+}
+
+func convertJson(from, to interface{}) error {
+	contents, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(contents, to)
+}
@@ -0,0 +1,300 @@
+package local_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/local"
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+const openMetrics = `# TYPE reqs counter
+reqs_total{code="200"} 0 0
+reqs_total{code="200"} 60 60
+reqs_total{code="200"} 120 120
+reqs_total{code="500"} 0 0
+reqs_total{code="500"} 6 60
+# TYPE up gauge
+up{job="a"} 1 120
+# EOF
+`
+
+var _ = Describe("Local", func() {
+	Describe("Parse()", func() {
+		It("reads OpenMetrics text", func() {
+			storage, err := local.Parse([]byte(openMetrics), time.Now())
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(storage.MaxTime()).To(Equal(time.Unix(120, 0)))
+		})
+
+		It("reads the Prometheus text format, using the given time for samples without one", func() {
+			storage, err := local.Parse([]byte("up 1\nup{job=\"a\"} 1 5000\n"), time.Unix(10, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(storage.MaxTime()).To(Equal(time.Unix(10, 0)))
+		})
+
+		It("returns an error for duplicate samples", func() {
+			_, err := local.Parse([]byte("up 1 1000\nup 2 1000\n"), time.Now())
+
+			Expect(err).To(MatchError(ContainSubstring("more than one sample")))
+		})
+
+		It("returns an error for invalid input", func() {
+			_, err := local.Parse([]byte("up{ 1\n"), time.Now())
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error for empty input", func() {
+			_, err := local.Parse([]byte("# EOF\n"), time.Now())
+
+			Expect(err).To(MatchError("no samples found"))
+		})
+	})
+
+	Describe("API", func() {
+		var api *local.API
+
+		BeforeEach(func() {
+			storage, err := local.Parse([]byte(openMetrics), time.Now())
+			Expect(err).ToNot(HaveOccurred())
+
+			api = local.NewAPI(storage)
+		})
+
+		It("runs instant queries", func() {
+			value, _, err := api.Query(context.Background(), `reqs_total - reqs_total offset 1m`, time.Unix(60, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(ConsistOf(
+				&model.Sample{Metric: model.Metric{"code": "200"}, Value: 60, Timestamp: 60000},
+				&model.Sample{Metric: model.Metric{"code": "500"}, Value: 6, Timestamp: 60000},
+			))
+		})
+
+		It("runs range queries", func() {
+			value, _, err := api.QueryRange(context.Background(), `up`, v1.Range{
+				Start: time.Unix(60, 0),
+				End:   time.Unix(120, 0),
+				Step:  time.Minute,
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(model.Matrix{
+				{
+					Metric: model.Metric{"__name__": "up", "job": "a"},
+					Values: []model.SamplePair{{Timestamp: 120000, Value: 1}},
+				},
+			}))
+		})
+
+		It("collects stats", func() {
+			ctx, stats := query.WithStats(context.Background())
+
+			_, _, err := api.Query(ctx, `sum(reqs_total)`, time.Unix(120, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.Found).To(BeTrue())
+			Expect(stats.Samples.TotalQueryableSamples).To(BeNumerically(">", 0))
+		})
+
+		It("returns scalars", func() {
+			value, _, err := api.Query(context.Background(), `scalar(up)`, time.Unix(120, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(&model.Scalar{Value: 1, Timestamp: 120000}))
+		})
+
+		It("returns errors for invalid queries", func() {
+			_, _, err := api.Query(context.Background(), `rate(`, time.Unix(120, 0))
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("OpenTSDB()", func() {
+		var dataDir, blockDir string
+
+		BeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "quickprom-test")
+			Expect(err).ToNot(HaveOccurred())
+
+			writer, err := tsdb.NewBlockWriter(promslog.NewNopLogger(), dataDir, tsdb.DefaultBlockDuration)
+			Expect(err).ToNot(HaveOccurred())
+
+			appender := writer.Appender(context.Background())
+			for _, t := range []int64{0, 60000, 120000} {
+				_, err = appender.Append(0, labels.FromStrings("__name__", "up", "job", "a"), t, float64(t/60000))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(appender.Commit()).To(Succeed())
+
+			id, err := writer.Flush(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.Close()).To(Succeed())
+
+			blockDir = filepath.Join(dataDir, id.String())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dataDir)
+		})
+
+		It("queries data directories", func() {
+			db, err := local.OpenTSDB(dataDir)
+			Expect(err).ToNot(HaveOccurred())
+			defer db.Close()
+
+			Expect(db.MaxTime()).To(Equal(time.Unix(120, 0)))
+
+			value, _, err := local.NewAPI(db).Query(context.Background(), `up`, time.Unix(60, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(model.Vector{
+				{Metric: model.Metric{"__name__": "up", "job": "a"}, Value: 1, Timestamp: 60000},
+			}))
+		})
+
+		It("queries single blocks", func() {
+			db, err := local.OpenTSDB(blockDir)
+			Expect(err).ToNot(HaveOccurred())
+			defer db.Close()
+
+			Expect(db.MaxTime()).To(Equal(time.Unix(120, 0)))
+
+			value, _, err := local.NewAPI(db).Query(context.Background(), `sum_over_time(up[5m])`, time.Unix(120, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(model.Vector{
+				{Metric: model.Metric{"job": "a"}, Value: 3, Timestamp: 120000},
+			}))
+		})
+
+		It("queries data directories with a write-ahead log", func() {
+			walDir, err := ioutil.TempDir("", "quickprom-test")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(walDir)
+
+			writable, err := tsdb.Open(walDir, promslog.NewNopLogger(), nil, tsdb.DefaultOptions(), nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			appender := writable.Appender(context.Background())
+			_, err = appender.Append(0, labels.FromStrings("__name__", "up", "job", "b"), 60000, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(appender.Commit()).To(Succeed())
+			Expect(writable.Close()).To(Succeed())
+
+			db, err := local.OpenTSDB(walDir)
+			Expect(err).ToNot(HaveOccurred())
+			defer db.Close()
+
+			value, _, err := local.NewAPI(db).Query(context.Background(), `up`, time.Unix(60, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(model.Vector{
+				{Metric: model.Metric{"__name__": "up", "job": "b"}, Value: 1, Timestamp: 60000},
+			}))
+		})
+
+		It("returns an error for missing directories", func() {
+			_, err := local.OpenTSDB(filepath.Join(dataDir, "missing"))
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("NewRemoteRead()", func() {
+		var server *httptest.Server
+		var requests []*prompb.ReadRequest
+
+		BeforeEach(func() {
+			requests = nil
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req, err := remote.DecodeReadRequest(r)
+				Expect(err).ToNot(HaveOccurred())
+				requests = append(requests, req)
+
+				w.Header().Set("Content-Type", "application/x-protobuf")
+				err = remote.EncodeReadResponse(&prompb.ReadResponse{
+					Results: []*prompb.QueryResult{
+						{
+							Timeseries: []*prompb.TimeSeries{
+								{
+									Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}},
+									Samples: []prompb.Sample{
+										{Timestamp: 0, Value: 1},
+										{Timestamp: 55000, Value: 0},
+										{Timestamp: 115000, Value: 1},
+									},
+								},
+							},
+						},
+					},
+				}, w)
+				Expect(err).ToNot(HaveOccurred())
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("returns raw samples for range selectors", func() {
+			storage, err := local.NewRemoteRead(server.URL, http.DefaultTransport, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			value, _, err := local.NewAPI(storage).Query(context.Background(), `up{job="a"}[2m]`, time.Unix(120, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(model.Matrix{
+				{
+					Metric: model.Metric{"__name__": "up", "job": "a"},
+					Values: []model.SamplePair{
+						{Timestamp: 55000, Value: 0},
+						{Timestamp: 115000, Value: 1},
+					},
+				},
+			}))
+
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Queries[0].Matchers).To(ContainElement(&prompb.LabelMatcher{
+				Type:  prompb.LabelMatcher_EQ,
+				Name:  "job",
+				Value: "a",
+			}))
+		})
+
+		It("returns errors from the server", func() {
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "no such endpoint", http.StatusNotFound)
+			})
+
+			storage, err := local.NewRemoteRead(server.URL, http.DefaultTransport, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, _, err = local.NewAPI(storage).Query(context.Background(), `up`, time.Unix(120, 0))
+
+			Expect(err).To(MatchError(ContainSubstring("no such endpoint")))
+		})
+	})
+})
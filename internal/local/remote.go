@@ -0,0 +1,35 @@
+package local
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// NewRemoteRead returns storage that fetches raw samples from the remote-read endpoint at
+// `rawUrl` as each query needs them.
+func NewRemoteRead(rawUrl string, roundTripper http.RoundTripper, timeout time.Duration) (storage.Queryable, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := remote.NewReadClient("quickprom", &remote.ClientConfig{
+		URL:     &config_util.URL{URL: parsedUrl},
+		Timeout: model.Duration(timeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the same TLS and authentication settings as queries against a target
+	client.(*remote.Client).Client.Transport = roundTripper
+
+	return remote.NewSampleAndChunkQueryableClient(client, labels.EmptyLabels(), nil, true, nil), nil
+}
@@ -0,0 +1,231 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+)
+
+// Storage holds series loaded from exposition text in memory, so they can be queried with PromQL.
+type Storage struct {
+	series  []*series
+	minTime int64
+	maxTime int64
+}
+
+type series struct {
+	labels  labels.Labels
+	samples []chunks.Sample
+}
+
+// LoadFile reads a file in the OpenMetrics or Prometheus text format.
+func LoadFile(path string, now time.Time) (*Storage, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(contents, now)
+}
+
+// Parse reads OpenMetrics text if it ends with `# EOF`, and the Prometheus text format otherwise.
+// Samples without a timestamp are given the time `now`.
+func Parse(contents []byte, now time.Time) (*Storage, error) {
+	var parser textparse.Parser
+	if bytes.HasSuffix(bytes.TrimSpace(contents), []byte("# EOF")) {
+		parser = textparse.NewOpenMetricsParser(contents, nil)
+	} else {
+		parser = textparse.NewPromParser(contents, nil, false)
+	}
+
+	s := &Storage{
+		minTime: math.MaxInt64,
+		maxTime: math.MinInt64,
+	}
+	seriesByLabels := make(map[string]*series)
+	defaultTimestamp := now.UnixNano() / int64(time.Millisecond)
+
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if entry != textparse.EntrySeries {
+			continue
+		}
+
+		var lset labels.Labels
+		parser.Labels(&lset)
+		_, timestamp, value := parser.Series()
+
+		t := defaultTimestamp
+		if timestamp != nil {
+			t = *timestamp
+		}
+
+		key := lset.String()
+		loaded, ok := seriesByLabels[key]
+		if !ok {
+			loaded = &series{labels: lset}
+			seriesByLabels[key] = loaded
+			s.series = append(s.series, loaded)
+		}
+
+		loaded.samples = append(loaded.samples, floatSample{t: t, f: value})
+		s.minTime = min(s.minTime, t)
+		s.maxTime = max(s.maxTime, t)
+	}
+
+	if len(s.series) == 0 {
+		return nil, errors.New("no samples found")
+	}
+
+	for _, loaded := range s.series {
+		sort.SliceStable(loaded.samples, func(i, j int) bool {
+			return loaded.samples[i].T() < loaded.samples[j].T()
+		})
+
+		for i := 1; i < len(loaded.samples); i++ {
+			if loaded.samples[i].T() == loaded.samples[i-1].T() {
+				return nil, fmt.Errorf("%s has more than one sample at %d", loaded.labels, loaded.samples[i].T())
+			}
+		}
+	}
+
+	sort.Slice(s.series, func(i, j int) bool {
+		return labels.Compare(s.series[i].labels, s.series[j].labels) < 0
+	})
+
+	return s, nil
+}
+
+// MaxTime returns the time of the newest sample.
+func (s *Storage) MaxTime() time.Time {
+	return timeFromMillis(s.maxTime)
+}
+
+func timeFromMillis(t int64) time.Time {
+	return time.Unix(0, t*int64(time.Millisecond))
+}
+
+func (s *Storage) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &querier{storage: s}, nil
+}
+
+type querier struct {
+	storage *Storage
+}
+
+func (q *querier) Select(_ context.Context, _ bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	var matched []storage.Series
+
+	for _, loaded := range q.storage.series {
+		if matchesAll(loaded.labels, matchers) {
+			matched = append(matched, storage.NewListSeries(loaded.labels, loaded.samples))
+		}
+	}
+
+	return &seriesSet{series: matched, pos: -1}
+}
+
+func (q *querier) LabelValues(_ context.Context, name string, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	values := make(map[string]struct{})
+
+	for _, loaded := range q.storage.series {
+		if value := loaded.labels.Get(name); value != "" && matchesAll(loaded.labels, matchers) {
+			values[value] = struct{}{}
+		}
+	}
+
+	return sortedKeys(values), nil, nil
+}
+
+func (q *querier) LabelNames(_ context.Context, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	names := make(map[string]struct{})
+
+	for _, loaded := range q.storage.series {
+		if matchesAll(loaded.labels, matchers) {
+			loaded.labels.Range(func(l labels.Label) {
+				names[l.Name] = struct{}{}
+			})
+		}
+	}
+
+	return sortedKeys(names), nil, nil
+}
+
+func (q *querier) Close() error {
+	return nil
+}
+
+func matchesAll(lset labels.Labels, matchers []*labels.Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(lset.Get(matcher.Name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedKeys(set map[string]struct{}) (keys []string) {
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return
+}
+
+type seriesSet struct {
+	series []storage.Series
+	pos    int
+}
+
+func (s *seriesSet) Next() bool {
+	s.pos++
+	return s.pos < len(s.series)
+}
+
+func (s *seriesSet) At() storage.Series {
+	return s.series[s.pos]
+}
+
+func (s *seriesSet) Err() error {
+	return nil
+}
+
+func (s *seriesSet) Warnings() annotations.Annotations {
+	return nil
+}
+
+type floatSample struct {
+	t int64
+	f float64
+}
+
+func (s floatSample) T() int64                      { return s.t }
+func (s floatSample) ST() int64                     { return 0 }
+func (s floatSample) F() float64                    { return s.f }
+func (s floatSample) H() *histogram.Histogram       { return nil }
+func (s floatSample) FH() *histogram.FloatHistogram { return nil }
+func (s floatSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+func (s floatSample) Copy() chunks.Sample           { return s }
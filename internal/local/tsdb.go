@@ -0,0 +1,155 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// TSDB is a Prometheus data directory, or a single block copied out of one, opened read-only.
+type TSDB struct {
+	querier storage.Querier
+	close   func() error
+	maxTime time.Time
+}
+
+// OpenTSDB opens the data directory or block at `dir` without modifying it. Data directories with a
+// write-ahead log are opened with a sandbox in the temporary directory, which needs to be on the same
+// filesystem as `dir` if Prometheus is still running against it.
+func OpenTSDB(dir string) (*TSDB, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "meta.json")); err == nil {
+		return openBlocks([]string{dir})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "wal")); err == nil {
+		return openDataDir(dir)
+	}
+
+	// Without a write-ahead log, this is usually a directory of copied blocks
+	metaPaths, err := filepath.Glob(filepath.Join(dir, "*", "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metaPaths) == 0 {
+		return nil, fmt.Errorf("no blocks or write-ahead log found in %s", dir)
+	}
+
+	var blockDirs []string
+	for _, metaPath := range metaPaths {
+		blockDirs = append(blockDirs, filepath.Dir(metaPath))
+	}
+
+	return openBlocks(blockDirs)
+}
+
+func openDataDir(dir string) (*TSDB, error) {
+	sandboxDir, err := ioutil.TempDir("", "quickprom-tsdb")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := tsdb.OpenDBReadOnly(dir, sandboxDir, nil)
+	if err != nil {
+		os.RemoveAll(sandboxDir)
+		return nil, err
+	}
+
+	close := func() error {
+		defer os.RemoveAll(sandboxDir)
+		return db.Close()
+	}
+
+	// Every querier reloads the whole directory, so one is shared by all queries
+	querier, err := db.Querier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		close()
+		return nil, err
+	}
+
+	return &TSDB{
+		querier: querier,
+		close: func() error {
+			querier.Close()
+			return close()
+		},
+		// The write-ahead log may hold samples up to now, and finding the newest means reading it all
+		maxTime: time.Now(),
+	}, nil
+}
+
+func openBlocks(dirs []string) (*TSDB, error) {
+	var blocks []*tsdb.Block
+	var queriers []storage.Querier
+	maxTime := int64(math.MinInt64)
+
+	closeAll := func() error {
+		var errs []error
+		for _, querier := range queriers {
+			errs = append(errs, querier.Close())
+		}
+		for _, block := range blocks {
+			errs = append(errs, block.Close())
+		}
+
+		return errors.Join(errs...)
+	}
+
+	for _, dir := range dirs {
+		block, err := tsdb.OpenBlock(nil, dir, nil, tsdb.DefaultPostingsDecoderFactory)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		blocks = append(blocks, block)
+
+		querier, err := tsdb.NewBlockQuerier(block, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		queriers = append(queriers, querier)
+
+		// The end of a block is exclusive
+		maxTime = max(maxTime, block.MaxTime()-1)
+	}
+
+	return &TSDB{
+		querier: storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge),
+		close:   closeAll,
+		maxTime: timeFromMillis(maxTime),
+	}, nil
+}
+
+// MaxTime returns the end of the newest block, or now for data directories with a write-ahead log.
+func (t *TSDB) MaxTime() time.Time {
+	return t.maxTime
+}
+
+// Querier returns the querier shared by all queries, which is only closed with the TSDB itself.
+func (t *TSDB) Querier(mint, maxt int64) (storage.Querier, error) {
+	return sharedQuerier{t.querier}, nil
+}
+
+func (t *TSDB) Close() error {
+	return t.close()
+}
+
+type sharedQuerier struct {
+	storage.Querier
+}
+
+func (q sharedQuerier) Close() error {
+	return nil
+}
@@ -2,6 +2,9 @@ package output
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/common/model"
@@ -23,13 +26,40 @@ type FormattedScalar struct {
 
 type FormattedInstantVector struct {
 	FormattedValue
-	Time    time.Time
-	Samples []FormattedSample
+	Time            time.Time
+	Samples         []FormattedSample
+	ComparisonNames []string
+	// Set when samples can each be from a different time, so each is shown with its own
+	SampleTimes bool
+	// Only set when the samples are all buckets of classic histograms, or all native histograms
+	HistogramLabels []string
+	Histograms      []FormattedHistogram
+}
+
+type FormattedHistogram struct {
+	LabelValues []string
+	// Native histograms know their sum and hold every observation in their buckets, while classic
+	// histograms only know the observations up to their largest `le`
+	Native  bool
+	Sum     float64
+	Buckets []FormattedBucket
+}
+
+type FormattedBucket struct {
+	LowerBound float64
+	UpperBound float64
+	Label      string
+	Cumulative float64
 }
 
 type FormattedSample struct {
 	LabelValues []string
+	// Only set with SampleTimes
+	Time time.Time
+	// For native histograms, this is the count of observations
 	Value       float64
+	Histogram   *FormattedHistogram
+	Comparisons []*float64
 }
 
 type FormattedRangeVector struct {
@@ -37,7 +67,10 @@ type FormattedRangeVector struct {
 	MinTime   time.Time
 	MaxTime   time.Time
 	SeenTimes []time.Time
-	Series    []FormattedSeries
+	// Series are only read and formatted as they're rendered, so a source that doesn't hold the
+	// whole result keeps just one series in memory at a time
+	source      SeriesSource
+	seriesCount int
 }
 
 type FormattedSeries struct {
@@ -45,9 +78,40 @@ type FormattedSeries struct {
 	Values      []FormattedSamplePair
 }
 
+type SeriesStats struct {
+	Count int
+	// Samples that aren't numbers, including stale markers, are counted but left out of the rest
+	NaNCount int
+	First    float64
+	Last     float64
+	Min      float64
+	Max      float64
+	Mean     float64
+	Median   float64
+	P95      float64
+	Stddev   float64
+}
+
 type FormattedSamplePair struct {
-	Time  time.Time
-	Value float64
+	Time time.Time
+	// For native histograms, this is the count of observations
+	Value     float64
+	Histogram *FormattedHistogram
+}
+
+type FormattedDiff struct {
+	FormattedValue
+	BeforeName string
+	AfterName  string
+	Changes    []FormattedChange
+	OnlyBefore []FormattedSample
+	OnlyAfter  []FormattedSample
+}
+
+type FormattedChange struct {
+	LabelValues []string
+	Before      float64
+	After       float64
 }
 
 func FormatScalar(s *model.Scalar) *FormattedScalar {
@@ -92,23 +156,256 @@ func FormatInstantVector(v model.Vector) *FormattedInstantVector {
 
 	result.Time = v[0].Timestamp.Time()
 
+	allNative := true
 	for _, s := range v {
 		var labelValues []string
 		for _, varyingLabelName := range result.VaryingLabels {
 			labelValues = append(labelValues, string(s.Metric[model.LabelName(varyingLabelName)]))
 		}
 
-		result.Samples = append(result.Samples, FormattedSample{
+		sample := FormattedSample{
 			LabelValues: labelValues,
 			Value:       float64(s.Value),
+		}
+
+		if s.Histogram != nil {
+			sample.Histogram = FormatNativeHistogram(labelValues, s.Histogram)
+			sample.Value = float64(s.Histogram.Count)
+		} else {
+			allNative = false
+		}
+
+		result.Samples = append(result.Samples, sample)
+	}
+
+	if info.ClassicHistogram {
+		result.formatHistograms(v)
+	} else if allNative {
+		result.HistogramLabels = result.VaryingLabels
+		for _, sample := range result.Samples {
+			result.Histograms = append(result.Histograms, *sample.Histogram)
+		}
+	}
+
+	return result
+}
+
+// FormatLatestSamples formats the newest sample of each series, such as those received by remote
+// write, which unlike the result of a query can each be from a different time.
+func FormatLatestSamples(v model.Vector) *FormattedInstantVector {
+	result := FormatInstantVector(v)
+	result.SampleTimes = true
+
+	// Buckets are shown as plain samples, as a distribution would hide when each was received
+	result.HistogramLabels = nil
+	result.Histograms = nil
+
+	for i, s := range v {
+		result.Samples[i].Time = s.Timestamp.Time()
+	}
+
+	return result
+}
+
+// formatHistograms groups buckets into histograms by all of their labels other than `le`.
+func (f *FormattedInstantVector) formatHistograms(v model.Vector) {
+	for _, labelName := range f.VaryingLabels {
+		if labelName != model.BucketLabel {
+			f.HistogramLabels = append(f.HistogramLabels, labelName)
+		}
+	}
+
+	positions := make(map[model.Fingerprint]int)
+	for _, s := range v {
+		withoutBucket := s.Metric.Clone()
+		delete(withoutBucket, model.BucketLabel)
+		fingerprint := withoutBucket.Fingerprint()
+
+		pos, existed := positions[fingerprint]
+		if !existed {
+			pos = len(f.Histograms)
+			positions[fingerprint] = pos
+
+			f.Histograms = append(f.Histograms, FormattedHistogram{
+				LabelValues: getLabelValues(f.HistogramLabels, s.Metric),
+			})
+		}
+
+		upperBoundLabel := string(s.Metric[model.BucketLabel])
+		upperBound, _ := strconv.ParseFloat(upperBoundLabel, 64)
+
+		f.Histograms[pos].Buckets = append(f.Histograms[pos].Buckets, FormattedBucket{
+			UpperBound: upperBound,
+			Label:      upperBoundLabel,
+			Cumulative: float64(s.Value),
+		})
+	}
+
+	for _, histogram := range f.Histograms {
+		sort.Slice(histogram.Buckets, func(i, j int) bool {
+			return histogram.Buckets[i].UpperBound < histogram.Buckets[j].UpperBound
+		})
+
+		// Classic buckets start where the last one ended; like histogram_quantile(), the first
+		// bucket is assumed to start at 0 unless it holds negative observations.
+		for i := range histogram.Buckets {
+			if i != 0 {
+				histogram.Buckets[i].LowerBound = histogram.Buckets[i-1].UpperBound
+			} else if histogram.Buckets[i].UpperBound <= 0 {
+				histogram.Buckets[i].LowerBound = math.Inf(-1)
+			}
+		}
+	}
+}
+
+// FormatNativeHistogram turns the non-cumulative, possibly sparse buckets of a native histogram
+// into the same cumulative buckets used for classic histograms.
+func FormatNativeHistogram(labelValues []string, h *model.SampleHistogram) *FormattedHistogram {
+	result := &FormattedHistogram{
+		LabelValues: labelValues,
+		Native:      true,
+		Sum:         float64(h.Sum),
+	}
+
+	buckets := make(model.HistogramBuckets, len(h.Buckets))
+	copy(buckets, h.Buckets)
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Upper < buckets[j].Upper
+	})
+
+	cumulative := 0.0
+	for _, bucket := range buckets {
+		cumulative += float64(bucket.Count)
+
+		result.Buckets = append(result.Buckets, FormattedBucket{
+			LowerBound: float64(bucket.Lower),
+			UpperBound: float64(bucket.Upper),
+			Label:      nativeBucketLabel(bucket),
+			Cumulative: cumulative,
 		})
 	}
 
 	return result
 }
 
+func nativeBucketLabel(bucket *model.HistogramBucket) string {
+	var open, close string
+
+	// See the docs for `boundaries` in the Prometheus HTTP API
+	switch bucket.Boundaries {
+	case 0:
+		open, close = "(", "]"
+	case 1:
+		open, close = "[", ")"
+	case 2:
+		open, close = "(", ")"
+	default:
+		open, close = "[", "]"
+	}
+
+	return fmt.Sprintf("%s%g, %g%s", open, bucket.Lower, bucket.Upper, close)
+}
+
+// Count returns the total number of observations in the histogram.
+func (h *FormattedHistogram) Count() float64 {
+	if len(h.Buckets) == 0 {
+		return 0
+	}
+
+	return h.Buckets[len(h.Buckets)-1].Cumulative
+}
+
+// BucketCount returns the number of observations in just the given bucket.
+func (h *FormattedHistogram) BucketCount(i int) float64 {
+	if i == 0 {
+		return h.Buckets[0].Cumulative
+	}
+
+	return h.Buckets[i].Cumulative - h.Buckets[i-1].Cumulative
+}
+
+// Quantile estimates the given quantile the same way as Prometheus' histogram_quantile(), by
+// assuming observations are spread evenly within each bucket. It returns NaN if there are no
+// observations, or if a classic histogram has no +Inf bucket.
+func (h *FormattedHistogram) Quantile(q float64) float64 {
+	if len(h.Buckets) == 0 {
+		return math.NaN()
+	}
+
+	if !h.Native && (len(h.Buckets) < 2 || !math.IsInf(h.Buckets[len(h.Buckets)-1].UpperBound, 1)) {
+		return math.NaN()
+	}
+
+	observations := h.Count()
+	if observations == 0 {
+		return math.NaN()
+	}
+
+	rank := q * observations
+	b := sort.Search(len(h.Buckets)-1, func(i int) bool {
+		return h.Buckets[i].Cumulative >= rank
+	})
+	bucket := h.Buckets[b]
+
+	if math.IsInf(bucket.UpperBound, 1) {
+		return bucket.LowerBound
+	}
+
+	if math.IsInf(bucket.LowerBound, -1) {
+		return bucket.UpperBound
+	}
+
+	if b > 0 {
+		rank -= h.Buckets[b-1].Cumulative
+	}
+
+	return bucket.LowerBound + (bucket.UpperBound-bucket.LowerBound)*(rank/h.BucketCount(b))
+}
+
+// FormatInstantVectorComparison formats an instant vector along with the results of the same query
+// at other times, which are matched up to the samples of the original vector by their labels.
+// Samples that only appear in a comparison are left out.
+func FormatInstantVectorComparison(v model.Vector, comparisonNames []string, comparisons []model.Vector) *FormattedInstantVector {
+	result := FormatInstantVector(v)
+	if result.Empty {
+		return result
+	}
+
+	result.ComparisonNames = comparisonNames
+
+	info := InstantVectorInfo(v)
+	for _, comparison := range comparisons {
+		for _, sample := range comparison {
+			info.addValue(sample.Value)
+		}
+	}
+	info.normalizeValueInfo()
+	result.MinValueExp = info.MinValueExp
+	result.MaxValueExp = info.MaxValueExp
+	result.MaxValueFracLength = info.MaxValueFracLength
+
+	joined := joinVectors(append([]model.Vector{v}, comparisons...)...)
+	for i := range result.Samples {
+		for _, comparisonValue := range joined[i].Values[1:] {
+			result.Samples[i].Comparisons = append(
+				result.Samples[i].Comparisons,
+				(*float64)(comparisonValue),
+			)
+		}
+	}
+
+	return result
+}
+
 func FormatRangeVector(m model.Matrix) *FormattedRangeVector {
-	if len(m) == 0 {
+	return FormatRangeSeries(MatrixSource(m))
+}
+
+// FormatRangeSeries formats a range vector read from `source`, which is read once now for its
+// labels, times and scale, then again each time its series are rendered.
+func FormatRangeSeries(source SeriesSource) *FormattedRangeVector {
+	info := RangeSeriesInfo(source)
+	if info.length == 0 {
 		return &FormattedRangeVector{
 			FormattedValue: FormattedValue{
 				Empty: true,
@@ -118,7 +415,6 @@ func FormatRangeVector(m model.Matrix) *FormattedRangeVector {
 
 	result := &FormattedRangeVector{}
 
-	info := RangeVectorInfo(m)
 	result.CommonLabels = info.CommonLabels()
 	result.VaryingLabels = info.VaryingLabels()
 	result.MinValueExp = info.MinValueExp
@@ -129,25 +425,162 @@ func FormatRangeVector(m model.Matrix) *FormattedRangeVector {
 	result.MinTime = result.SeenTimes[0]
 	result.MaxTime = result.SeenTimes[len(result.SeenTimes)-1]
 
-	for _, s := range m {
-		var values []FormattedSamplePair
+	result.source = source
+	result.seriesCount = info.length
+
+	return result
+}
+
+// SeriesCount returns the number of series in the range vector.
+func (f *FormattedRangeVector) SeriesCount() int {
+	return f.seriesCount
+}
+
+// EachSeries formats each series in turn. The same FormattedSeries is reused for every series, so
+// `seriesFunc` must not keep it.
+func (f *FormattedRangeVector) EachSeries(seriesFunc func(series *FormattedSeries)) {
+	if f.Empty {
+		return
+	}
+
+	series := &FormattedSeries{}
+
+	f.source(func(s *model.SampleStream) {
+		series.LabelValues = getLabelValues(f.VaryingLabels, s.Metric)
+		series.Values = series.Values[:0]
 
 		for _, p := range s.Values {
-			values = append(values, FormattedSamplePair{
+			series.Values = append(series.Values, FormattedSamplePair{
 				Time:  p.Timestamp.Time(),
 				Value: float64(p.Value),
 			})
 		}
 
-		result.Series = append(result.Series, FormattedSeries{
-			LabelValues: getLabelValues(result.VaryingLabels, s.Metric),
-			Values:      values,
-		})
+		for _, p := range s.Histograms {
+			series.Values = append(series.Values, FormattedSamplePair{
+				Time:      p.Timestamp.Time(),
+				Value:     float64(p.Histogram.Count),
+				Histogram: FormatNativeHistogram(series.LabelValues, p.Histogram),
+			})
+		}
+
+		// A series can switch between floats and histograms partway through
+		if len(s.Values) != 0 && len(s.Histograms) != 0 {
+			sort.SliceStable(series.Values, func(i, j int) bool {
+				return series.Values[i].Time.Before(series.Values[j].Time)
+			})
+		}
+
+		seriesFunc(series)
+	})
+}
+
+// FormatDiff lines up the samples in two results of the same query by their labels. Samples that
+// only exist in one result are kept separately.
+func FormatDiff(beforeName string, before model.Vector, afterName string, after model.Vector) *FormattedDiff {
+	result := &FormattedDiff{
+		BeforeName: beforeName,
+		AfterName:  afterName,
+	}
+
+	if len(before) == 0 && len(after) == 0 {
+		result.Empty = true
+		return result
+	}
+
+	var combined model.Vector
+	combined = append(combined, before...)
+	combined = append(combined, after...)
+
+	info := InstantVectorInfo(combined)
+	result.CommonLabels = info.CommonLabels()
+	result.VaryingLabels = info.VaryingLabels()
+	result.MinValueExp = info.MinValueExp
+	result.MaxValueExp = info.MaxValueExp
+	result.MaxValueFracLength = info.MaxValueFracLength
+
+	for _, joined := range joinVectors(before, after) {
+		labelValues := getLabelValues(result.VaryingLabels, joined.Metric)
+		beforeValue, afterValue := joined.Values[0], joined.Values[1]
+
+		switch {
+		case beforeValue == nil:
+			result.OnlyAfter = append(result.OnlyAfter, FormattedSample{
+				LabelValues: labelValues,
+				Value:       float64(*afterValue),
+			})
+		case afterValue == nil:
+			result.OnlyBefore = append(result.OnlyBefore, FormattedSample{
+				LabelValues: labelValues,
+				Value:       float64(*beforeValue),
+			})
+		default:
+			result.Changes = append(result.Changes, FormattedChange{
+				LabelValues: labelValues,
+				Before:      float64(*beforeValue),
+				After:       float64(*afterValue),
+			})
+		}
 	}
 
 	return result
 }
 
+func (c *FormattedChange) Change() float64 {
+	return c.After - c.Before
+}
+
+// PercentChange returns the change relative to the before value, and false if there was no before
+// value to compare to or either value isn't a finite number.
+func (c *FormattedChange) PercentChange() (float64, bool) {
+	if c.Before == 0 || !isFinite(c.Before) || !isFinite(c.After) {
+		return 0, false
+	}
+
+	return (c.After - c.Before) / math.Abs(c.Before) * 100, true
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+type joinedSample struct {
+	Metric model.Metric
+	Values []*model.SampleValue
+}
+
+// joinVectors matches up samples with identical labels across several instant vectors, keeping the
+// order in which they first appear. Each joined sample has one value per vector, which is nil if
+// that vector had no matching sample.
+func joinVectors(vectors ...model.Vector) (result []joinedSample) {
+	positions := make(map[model.Fingerprint]int)
+
+	for i, vector := range vectors {
+		for _, sample := range vector {
+			fingerprint := sample.Metric.Fingerprint()
+
+			pos, existed := positions[fingerprint]
+			if !existed {
+				pos = len(result)
+				positions[fingerprint] = pos
+
+				result = append(result, joinedSample{
+					Metric: sample.Metric,
+					Values: make([]*model.SampleValue, len(vectors)),
+				})
+			}
+
+			value := sample.Value
+			if sample.Histogram != nil {
+				value = model.SampleValue(sample.Histogram.Count)
+			}
+			result[pos].Values[i] = &value
+		}
+	}
+
+	return
+}
+
 func getLabelValues(labelNames []string, metric model.Metric) (labelValues []string) {
 	for _, labelName := range labelNames {
 		labelValues = append(labelValues, string(metric[model.LabelName(labelName)]))
@@ -156,36 +589,121 @@ func getLabelValues(labelNames []string, metric model.Metric) (labelValues []str
 	return
 }
 
-func (f *FormattedRangeVector) CollateSeriesValuesByTime() (result [][]*float64) {
-	for _, series := range f.Series {
-		var row []*float64
+// CollateByTime lines up the samples of a series with SeenTimes, leaving nil where the series has
+// no sample.
+func (f *FormattedRangeVector) CollateByTime(series *FormattedSeries) (row []*FormattedSamplePair) {
+	samplePos := 0
+	for _, seenTime := range f.SeenTimes {
+		for samplePos < len(series.Values) && series.Values[samplePos].Time.Before(seenTime) {
+			samplePos++
+		}
 
-		samplePos := 0
-		for _, seenTime := range f.SeenTimes {
-			for samplePos < len(series.Values) && series.Values[samplePos].Time.Before(seenTime) {
-				samplePos++
-			}
+		if samplePos < len(series.Values) && series.Values[samplePos].Time == seenTime {
+			row = append(row, &series.Values[samplePos])
+		} else {
+			row = append(row, nil)
+		}
+	}
 
-			if samplePos < len(series.Values) && series.Values[samplePos].Time == seenTime {
-				row = append(row, (*float64)(&(series.Values[samplePos].Value)))
-			} else {
-				row = append(row, nil)
-			}
+	return
+}
+
+// Stats summarizes the values of a series. If the series has no values, only Count is set.
+func (s *FormattedSeries) Stats() (stats SeriesStats) {
+	stats.Count = len(s.Values)
+	if stats.Count == 0 {
+		return
+	}
+
+	stats.First = s.Values[0].Value
+	stats.Last = s.Values[stats.Count-1].Value
+
+	sorted := make([]float64, 0, stats.Count)
+	sum := 0.0
+	for _, pair := range s.Values {
+		if math.IsNaN(pair.Value) {
+			stats.NaNCount++
+			continue
 		}
 
-		result = append(result, row)
+		sorted = append(sorted, pair.Value)
+		sum += pair.Value
+	}
+
+	if len(sorted) == 0 {
+		stats.Min = math.NaN()
+		stats.Max = math.NaN()
+		stats.Mean = math.NaN()
+		stats.Median = math.NaN()
+		stats.P95 = math.NaN()
+		stats.Stddev = math.NaN()
+
+		return
+	}
+
+	sort.Float64s(sorted)
+
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	stats.Mean = sum / float64(len(sorted))
+	stats.Median = quantile(sorted, 0.5)
+	stats.P95 = quantile(sorted, 0.95)
+
+	squaredDeviations := 0.0
+	for _, value := range sorted {
+		squaredDeviations += (value - stats.Mean) * (value - stats.Mean)
 	}
+	stats.Stddev = math.Sqrt(squaredDeviations / float64(len(sorted)))
 
 	return
 }
 
+// quantile linearly interpolates between the closest ranks of already-sorted values.
+func quantile(sorted []float64, q float64) float64 {
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// FormatFloats works out how to format just `values`, for results whose series or columns are
+// formatted separately.
+func FormatFloats(values []float64) *FormattedValue {
+	info := FloatsInfo(values)
+
+	return &FormattedValue{
+		MinValueExp:        info.MinValueExp,
+		MaxValueExp:        info.MaxValueExp,
+		MaxValueFracLength: info.MaxValueFracLength,
+	}
+}
+
 func (f *FormattedValue) BestFloatFormat() string {
 	prec := f.MaxValueFracLength
 	if prec > 6 {
 		prec = 6
 	}
 
-	if f.MinValueExp <= -4 || f.MaxValueExp >= 6 {
+	return f.floatFormatWithPrecision(prec)
+}
+
+// FloatFormat returns BestFloatFormat, unless `opts` fixes the precision or number of significant
+// figures.
+func (f *FormattedValue) FloatFormat(opts *RenderOptions) string {
+	if opts.SigFigs != 0 {
+		return fmt.Sprintf("%%.%dg", opts.SigFigs)
+	}
+
+	if opts.FixedPrecision {
+		return f.floatFormatWithPrecision(opts.Precision)
+	}
+
+	return f.BestFloatFormat()
+}
+
+func (f *FormattedValue) floatFormatWithPrecision(prec int) string {
+	if UsesExponent(f.MinValueExp, f.MaxValueExp) {
 		return fmt.Sprintf("%%.%de", prec)
 	}
 
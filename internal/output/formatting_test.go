@@ -1,9 +1,12 @@
 package output_test
 
 import (
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/value"
 
 	"github.com/pianohacker/quickprom/internal/output"
 
@@ -95,6 +98,250 @@ var _ = Describe("Formatting", func() {
 		})
 	})
 
+	Describe("FormatLatestSamples()", func() {
+		It("keeps the time of each sample", func() {
+			formatted := output.FormatLatestSamples(model.Vector{
+				{Timestamp: 4000, Metric: model.Metric{"job": "a"}, Value: 1},
+				{Timestamp: 9000, Metric: model.Metric{"job": "b"}, Value: 2},
+			})
+
+			Expect(formatted.SampleTimes).To(BeTrue())
+			Expect(formatted.Samples[0].Time).To(BeTemporally("==", time.Unix(4, 0)))
+			Expect(formatted.Samples[1].Time).To(BeTemporally("==", time.Unix(9, 0)))
+		})
+
+		It("keeps histogram buckets as plain samples", func() {
+			formatted := output.FormatLatestSamples(model.Vector{
+				{Timestamp: 4000, Metric: model.Metric{"__name__": "a_bucket", "le": "0.5"}, Value: 3},
+				{Timestamp: 9000, Metric: model.Metric{"__name__": "a_bucket", "le": "+Inf"}, Value: 4},
+			})
+
+			Expect(formatted.Histograms).To(BeNil())
+			Expect(formatted.Samples).To(HaveLen(2))
+			Expect(formatted.Samples[1].Time).To(BeTemporally("==", time.Unix(9, 0)))
+		})
+
+		It("can handle no samples", func() {
+			Expect(output.FormatLatestSamples(model.Vector{}).Empty).To(BeTrue())
+		})
+	})
+
+	Describe("FormatInstantVector() with histograms", func() {
+		It("groups buckets into sorted histograms", func() {
+			formatted := output.FormatInstantVector(model.Vector{
+				{Metric: model.Metric{"__name__": "a_bucket", "handler": "a", "le": "+Inf"}, Value: 4},
+				{Metric: model.Metric{"__name__": "a_bucket", "handler": "a", "le": "0.5"}, Value: 3},
+				{Metric: model.Metric{"__name__": "a_bucket", "handler": "b", "le": "0.5"}, Value: 1},
+				{Metric: model.Metric{"__name__": "a_bucket", "handler": "b", "le": "+Inf"}, Value: 2},
+			})
+
+			Expect(formatted.HistogramLabels).To(Equal([]string{"handler"}))
+			Expect(formatted.Histograms).To(Equal([]output.FormattedHistogram{
+				{
+					LabelValues: []string{"a"},
+					Buckets: []output.FormattedBucket{
+						{UpperBound: 0.5, Label: "0.5", Cumulative: 3},
+						{LowerBound: 0.5, UpperBound: math.Inf(1), Label: "+Inf", Cumulative: 4},
+					},
+				},
+				{
+					LabelValues: []string{"b"},
+					Buckets: []output.FormattedBucket{
+						{UpperBound: 0.5, Label: "0.5", Cumulative: 1},
+						{LowerBound: 0.5, UpperBound: math.Inf(1), Label: "+Inf", Cumulative: 2},
+					},
+				},
+			}))
+		})
+
+		It("starts the first bucket below 0 if it has negative observations", func() {
+			formatted := output.FormatInstantVector(model.Vector{
+				{Metric: model.Metric{"le": "-1"}, Value: 1},
+				{Metric: model.Metric{"le": "+Inf"}, Value: 2},
+			})
+
+			Expect(formatted.Histograms[0].Buckets[0].LowerBound).To(Equal(math.Inf(-1)))
+		})
+
+		It("doesn't group other instant vectors", func() {
+			formatted := output.FormatInstantVector(model.Vector{
+				{Metric: model.Metric{"handler": "a"}, Value: 4},
+			})
+
+			Expect(formatted.Histograms).To(BeNil())
+		})
+
+		It("formats native histograms", func() {
+			formatted := output.FormatInstantVector(model.Vector{
+				{
+					Metric: model.Metric{"handler": "a"},
+					Histogram: &model.SampleHistogram{
+						Count: 5,
+						Sum:   2.5,
+						Buckets: model.HistogramBuckets{
+							{Boundaries: 0, Lower: 0.5, Upper: 1, Count: 2},
+							{Boundaries: 3, Lower: 0, Upper: 0, Count: 1},
+							{Boundaries: 0, Lower: 0.25, Upper: 0.5, Count: 2},
+						},
+					},
+				},
+				{
+					Metric:    model.Metric{"handler": "b"},
+					Histogram: &model.SampleHistogram{},
+				},
+			})
+
+			expected := output.FormattedHistogram{
+				LabelValues: []string{"a"},
+				Native:      true,
+				Sum:         2.5,
+				Buckets: []output.FormattedBucket{
+					{LowerBound: 0, UpperBound: 0, Label: "[0, 0]", Cumulative: 1},
+					{LowerBound: 0.25, UpperBound: 0.5, Label: "(0.25, 0.5]", Cumulative: 3},
+					{LowerBound: 0.5, UpperBound: 1, Label: "(0.5, 1]", Cumulative: 5},
+				},
+			}
+
+			Expect(formatted.HistogramLabels).To(Equal([]string{"handler"}))
+			Expect(formatted.Histograms).To(Equal([]output.FormattedHistogram{
+				expected,
+				{LabelValues: []string{"b"}, Native: true},
+			}))
+			Expect(formatted.Samples[0].Value).To(Equal(5.0))
+			Expect(formatted.Samples[0].Histogram).To(Equal(&expected))
+		})
+
+		It("only keeps summaries when native histograms are mixed with other samples", func() {
+			formatted := output.FormatInstantVector(model.Vector{
+				{
+					Metric:    model.Metric{"handler": "a"},
+					Histogram: &model.SampleHistogram{Count: 5, Sum: 2.5},
+				},
+				{Metric: model.Metric{"handler": "b"}, Value: 4},
+			})
+
+			Expect(formatted.Histograms).To(BeNil())
+			Expect(formatted.Samples[0].Value).To(Equal(5.0))
+			Expect(formatted.Samples[0].Histogram.Sum).To(Equal(2.5))
+			Expect(formatted.Samples[1].Histogram).To(BeNil())
+		})
+	})
+
+	Describe("FormattedHistogram", func() {
+		histogram := output.FormattedHistogram{
+			Buckets: []output.FormattedBucket{
+				{UpperBound: 0.1, Cumulative: 10},
+				{LowerBound: 0.1, UpperBound: 0.5, Cumulative: 50},
+				{LowerBound: 0.5, UpperBound: 1, Cumulative: 80},
+				{LowerBound: 1, UpperBound: math.Inf(1), Cumulative: 100},
+			},
+		}
+
+		It("counts observations", func() {
+			Expect(histogram.Count()).To(Equal(100.0))
+			Expect(histogram.BucketCount(0)).To(Equal(10.0))
+			Expect(histogram.BucketCount(1)).To(Equal(40.0))
+		})
+
+		DescribeTable("estimates quantiles",
+			func(q float64, expected float64) {
+				Expect(histogram.Quantile(q)).To(BeNumerically("~", expected))
+			},
+
+			Entry("in the first bucket", 0.05, 0.05),
+			Entry("in a middle bucket", 0.3, 0.3),
+			Entry("at a bucket boundary", 0.5, 0.5),
+			Entry("in the +Inf bucket", 0.99, 1.0),
+		)
+
+		It("returns NaN without a +Inf bucket", func() {
+			histogram := output.FormattedHistogram{
+				Buckets: []output.FormattedBucket{
+					{UpperBound: 0.1, Cumulative: 10},
+					{LowerBound: 0.1, UpperBound: 0.5, Cumulative: 50},
+				},
+			}
+
+			Expect(math.IsNaN(histogram.Quantile(0.5))).To(BeTrue())
+		})
+
+		It("doesn't need a +Inf bucket for native histograms", func() {
+			histogram := output.FormattedHistogram{
+				Native: true,
+				Buckets: []output.FormattedBucket{
+					{LowerBound: -1, UpperBound: 0, Cumulative: 10},
+					{LowerBound: 0, UpperBound: 1, Cumulative: 30},
+				},
+			}
+
+			Expect(histogram.Quantile(0.25)).To(BeNumerically("~", -0.25))
+			Expect(histogram.Quantile(0.5)).To(BeNumerically("~", 0.25))
+		})
+
+		It("returns NaN without observations", func() {
+			histogram := output.FormattedHistogram{
+				Buckets: []output.FormattedBucket{
+					{UpperBound: 0.1, Cumulative: 0},
+					{LowerBound: 0.1, UpperBound: math.Inf(1), Cumulative: 0},
+				},
+			}
+
+			Expect(math.IsNaN(histogram.Quantile(0.5))).To(BeTrue())
+		})
+	})
+
+	Describe("FormatInstantVectorComparison()", func() {
+		It("matches up comparison values by labels", func() {
+			formatted := output.FormatInstantVectorComparison(
+				model.Vector{
+					{Metric: model.Metric{"job": "a"}, Value: 1},
+					{Metric: model.Metric{"job": "b"}, Value: 2},
+				},
+				[]string{"1d ago", "1w ago"},
+				[]model.Vector{
+					{
+						{Metric: model.Metric{"job": "b"}, Value: 3},
+						{Metric: model.Metric{"job": "c"}, Value: 4},
+					},
+					{
+						{Metric: model.Metric{"job": "a"}, Value: 0.5},
+					},
+				},
+			)
+
+			Expect(formatted.ComparisonNames).To(Equal([]string{"1d ago", "1w ago"}))
+			Expect(formatted.Samples).To(Equal([]output.FormattedSample{
+				{
+					LabelValues: []string{"a"},
+					Value:       1,
+					Comparisons: []*float64{nil, fptr(0.5)},
+				},
+				{
+					LabelValues: []string{"b"},
+					Value:       2,
+					Comparisons: []*float64{fptr(3), nil},
+				},
+			}))
+		})
+
+		It("takes comparison values into account when formatting", func() {
+			formatted := output.FormatInstantVectorComparison(
+				model.Vector{
+					{Metric: model.Metric{"job": "a"}, Value: 1},
+				},
+				[]string{"1d ago"},
+				[]model.Vector{
+					{
+						{Metric: model.Metric{"job": "a"}, Value: 0.25},
+					},
+				},
+			)
+
+			Expect(formatted.MinValueExp).To(Equal(-1))
+			Expect(formatted.MaxValueFracLength).To(Equal(2))
+		})
+	})
+
 	Describe("FormatRangeVector()", func() {
 		It("can handle an empty range vector", func() {
 			formatted := output.FormatRangeVector(model.Matrix{})
@@ -128,7 +375,7 @@ var _ = Describe("Formatting", func() {
 			Expect(formatted.VaryingLabels).To(BeEmpty())
 			Expect(formatted.MinTime).To(BeTemporally("~", time.Unix(0, 1e6)))
 			Expect(formatted.MaxTime).To(BeTemporally("~", time.Unix(0, 2e6)))
-			Expect(formatted.Series).To(Equal([]output.FormattedSeries{
+			Expect(allSeries(formatted)).To(Equal([]output.FormattedSeries{
 				{
 					Values: []output.FormattedSamplePair{
 						{
@@ -144,6 +391,43 @@ var _ = Describe("Formatting", func() {
 			}))
 		})
 
+		It("interleaves native histograms with float values", func() {
+			formatted := output.FormatRangeVector(model.Matrix{
+				{
+					Metric: model.Metric{"label": "value"},
+					Values: []model.SamplePair{
+						{Timestamp: 3, Value: 13},
+					},
+					Histograms: []model.SampleHistogramPair{
+						{Timestamp: 1, Histogram: &model.SampleHistogram{Count: 2, Sum: 4}},
+					},
+				},
+			})
+
+			Expect(formatted.SeenTimes).To(Equal([]time.Time{
+				time.Unix(0, 1e6),
+				time.Unix(0, 3e6),
+			}))
+			Expect(allSeries(formatted)).To(Equal([]output.FormattedSeries{
+				{
+					Values: []output.FormattedSamplePair{
+						{
+							Time:  time.Unix(0, 1e6),
+							Value: 2,
+							Histogram: &output.FormattedHistogram{
+								Native: true,
+								Sum:    4,
+							},
+						},
+						{
+							Time:  time.Unix(0, 3e6),
+							Value: 13,
+						},
+					},
+				},
+			}))
+		})
+
 		It("can handle a multi-series range vector", func() {
 			formatted := output.FormatRangeVector(model.Matrix{
 				{
@@ -193,7 +477,7 @@ var _ = Describe("Formatting", func() {
 			}))
 			Expect(formatted.MinTime).To(BeTemporally("~", time.Unix(0, 1e6)))
 			Expect(formatted.MaxTime).To(BeTemporally("~", time.Unix(0, 4e6)))
-			Expect(formatted.Series).To(Equal([]output.FormattedSeries{
+			Expect(allSeries(formatted)).To(Equal([]output.FormattedSeries{
 				{
 					LabelValues: []string{
 						"varying-value-1",
@@ -249,6 +533,131 @@ var _ = Describe("Formatting", func() {
 		})
 	})
 
+	Describe("FormatRangeSeries()", func() {
+		// A source that decodes each series again every time it's read, like a result read from disk
+		var reads int
+		source := func(seriesFunc func(series *model.SampleStream)) {
+			reads++
+
+			for i := 0; i < 3; i++ {
+				seriesFunc(&model.SampleStream{
+					Metric: model.Metric{"instance": model.LabelValue(fmt.Sprintf("host-%d", i))},
+					Values: []model.SamplePair{
+						{Timestamp: model.Time(1000 * i), Value: model.SampleValue(i) + 0.5},
+					},
+				})
+			}
+		}
+
+		BeforeEach(func() {
+			reads = 0
+		})
+
+		It("reads the series once to find their labels, times and scale", func() {
+			formatted := output.FormatRangeSeries(source)
+
+			Expect(reads).To(Equal(1))
+			Expect(formatted.SeriesCount()).To(Equal(3))
+			Expect(formatted.VaryingLabels).To(Equal([]string{"instance"}))
+			Expect(formatted.SeenTimes).To(HaveLen(3))
+			Expect(formatted.MaxValueFracLength).To(Equal(1))
+		})
+
+		It("reads the series again each time they're formatted", func() {
+			formatted := output.FormatRangeSeries(source)
+
+			Expect(allSeries(formatted)).To(HaveLen(3))
+			Expect(allSeries(formatted)[2].LabelValues).To(Equal([]string{"host-2"}))
+			Expect(reads).To(Equal(3))
+		})
+
+		It("can handle a source without any series", func() {
+			formatted := output.FormatRangeSeries(func(func(series *model.SampleStream)) {})
+
+			Expect(formatted.Empty).To(BeTrue())
+			Expect(allSeries(formatted)).To(BeEmpty())
+		})
+	})
+
+	Describe("FormatDiff()", func() {
+		It("can handle two empty instant vectors", func() {
+			formatted := output.FormatDiff("before", model.Vector{}, "after", model.Vector{})
+
+			Expect(formatted.Empty).To(BeTrue())
+		})
+
+		It("matches up samples by their labels", func() {
+			formatted := output.FormatDiff(
+				"before",
+				model.Vector{
+					{
+						Metric: model.Metric{"shared-label": "shared-value", "job": "a"},
+						Value:  1,
+					},
+					{
+						Metric: model.Metric{"shared-label": "shared-value", "job": "b"},
+						Value:  2,
+					},
+					{
+						Metric: model.Metric{"shared-label": "shared-value", "job": "c"},
+						Value:  3,
+					},
+				},
+				"after",
+				model.Vector{
+					{
+						Metric: model.Metric{"shared-label": "shared-value", "job": "d"},
+						Value:  4,
+					},
+					{
+						Metric: model.Metric{"shared-label": "shared-value", "job": "b"},
+						Value:  5,
+					},
+					{
+						Metric: model.Metric{"shared-label": "shared-value", "job": "a"},
+						Value:  6,
+					},
+				},
+			)
+
+			Expect(formatted.Empty).To(BeFalse())
+			Expect(formatted.BeforeName).To(Equal("before"))
+			Expect(formatted.AfterName).To(Equal("after"))
+			Expect(formatted.CommonLabels).To(Equal(map[string]string{
+				"shared-label": "shared-value",
+			}))
+			Expect(formatted.VaryingLabels).To(Equal([]string{"job"}))
+			Expect(formatted.Changes).To(Equal([]output.FormattedChange{
+				{LabelValues: []string{"a"}, Before: 1, After: 6},
+				{LabelValues: []string{"b"}, Before: 2, After: 5},
+			}))
+			Expect(formatted.OnlyBefore).To(Equal([]output.FormattedSample{
+				{LabelValues: []string{"c"}, Value: 3},
+			}))
+			Expect(formatted.OnlyAfter).To(Equal([]output.FormattedSample{
+				{LabelValues: []string{"d"}, Value: 4},
+			}))
+		})
+	})
+
+	DescribeTable("FormattedChange.PercentChange",
+		func(before, after float64, expected float64, expectedOk bool) {
+			change := output.FormattedChange{Before: before, After: after}
+
+			percent, ok := change.PercentChange()
+
+			Expect(ok).To(Equal(expectedOk))
+			Expect(percent).To(BeNumerically("~", expected))
+		},
+
+		Entry("increase", 2.0, 3.0, 50.0, true),
+		Entry("decrease", 4.0, 1.0, -75.0, true),
+		Entry("increase from a negative number", -2.0, -1.0, 50.0, true),
+		Entry("change from zero", 0.0, 1.0, 0.0, false),
+		Entry("change from NaN", math.NaN(), 1.0, 0.0, false),
+		Entry("change to infinity", 1.0, math.Inf(1), 0.0, false),
+	)
+
 	DescribeTable("SharedDateParts",
 		func(
 			expected *output.DateParts,
@@ -330,7 +739,7 @@ var _ = Describe("Formatting", func() {
 		),
 	)
 
-	Describe("CollateSeriesValuesByTime", func() {
+	Describe("CollateByTime", func() {
 		It("fills gaps with nil", func() {
 			rangeVector := model.Matrix{
 				{
@@ -360,13 +769,105 @@ var _ = Describe("Formatting", func() {
 			}
 			formatted := output.FormatRangeVector(rangeVector)
 
-			Expect(formatted.CollateSeriesValuesByTime()).To(Equal([][]*float64{
+			var collated [][]*float64
+			formatted.EachSeries(func(series *output.FormattedSeries) {
+				var row []*float64
+				for _, sample := range formatted.CollateByTime(series) {
+					if sample == nil {
+						row = append(row, nil)
+					} else {
+						row = append(row, fptr(sample.Value))
+					}
+				}
+
+				collated = append(collated, row)
+			})
+
+			Expect(collated).To(Equal([][]*float64{
 				{fptr(11), nil, fptr(13), nil},
 				{nil, fptr(12), nil, fptr(14)},
 			}))
 		})
 	})
 
+	Describe("FormattedSeries.Stats", func() {
+		It("summarizes the values of a series", func() {
+			series := output.FormattedSeries{
+				Values: []output.FormattedSamplePair{
+					{Value: 4},
+					{Value: 1},
+					{Value: 3},
+					{Value: 2},
+					{Value: 5},
+				},
+			}
+
+			stats := series.Stats()
+
+			Expect(stats.Count).To(Equal(5))
+			Expect(stats.First).To(Equal(4.0))
+			Expect(stats.Last).To(Equal(5.0))
+			Expect(stats.Min).To(Equal(1.0))
+			Expect(stats.Max).To(Equal(5.0))
+			Expect(stats.Mean).To(Equal(3.0))
+			Expect(stats.Median).To(Equal(3.0))
+			Expect(stats.P95).To(BeNumerically("~", 4.8))
+			Expect(stats.Stddev).To(BeNumerically("~", 1.41421356))
+		})
+
+		It("interpolates the median of an even number of values", func() {
+			series := output.FormattedSeries{
+				Values: []output.FormattedSamplePair{
+					{Value: 1},
+					{Value: 2},
+				},
+			}
+
+			Expect(series.Stats().Median).To(Equal(1.5))
+		})
+
+		It("leaves values that aren't numbers out of the summary", func() {
+			series := output.FormattedSeries{
+				Values: []output.FormattedSamplePair{
+					{Value: 1e-6},
+					{Value: 2e-6},
+					{Value: math.NaN()},
+					{Value: math.Float64frombits(value.StaleNaN)},
+				},
+			}
+
+			stats := series.Stats()
+
+			Expect(stats.Count).To(Equal(4))
+			Expect(stats.NaNCount).To(Equal(2))
+			Expect(stats.Min).To(Equal(1e-6))
+			Expect(stats.Max).To(Equal(2e-6))
+			Expect(stats.Mean).To(BeNumerically("~", 1.5e-6))
+			Expect(stats.Median).To(BeNumerically("~", 1.5e-6))
+			Expect(stats.Stddev).To(BeNumerically("~", 0.5e-6))
+		})
+
+		It("has no summary when no values are numbers", func() {
+			series := output.FormattedSeries{
+				Values: []output.FormattedSamplePair{
+					{Value: math.NaN()},
+				},
+			}
+
+			stats := series.Stats()
+
+			Expect(stats.NaNCount).To(Equal(1))
+			Expect(math.IsNaN(stats.Mean)).To(BeTrue())
+			Expect(math.IsNaN(stats.Median)).To(BeTrue())
+		})
+
+		It("only counts an empty series", func() {
+			series := output.FormattedSeries{}
+
+			Expect(series.Stats()).To(Equal(output.SeriesStats{}))
+		})
+	})
+
 	DescribeTable("BestFloatFormat",
 		func(f *output.FormattedValue, expected string) {
 			Expect(f.BestFloatFormat()).To(Equal(expected))
@@ -422,6 +923,45 @@ var _ = Describe("Formatting", func() {
 			"%.5e",
 		),
 	)
+
+	DescribeTable("FloatFormat",
+		func(opts *output.RenderOptions, expected string) {
+			f := &output.FormattedValue{
+				MinValueExp:        -6,
+				MaxValueExp:        1,
+				MaxValueFracLength: 3,
+			}
+
+			Expect(f.FloatFormat(opts)).To(Equal(expected))
+		},
+
+		Entry("defaults to the best format", &output.RenderOptions{}, "%.3e"),
+		Entry("keeps the best notation with a fixed precision", &output.RenderOptions{FixedPrecision: true, Precision: 1}, "%.1e"),
+		Entry("uses significant figures", &output.RenderOptions{SigFigs: 4}, "%.4g"),
+	)
+
+	Describe("FormatFloats", func() {
+		It("works out the format of just the given values", func() {
+			Expect(output.FormatFloats([]float64{12.5, 1e4}).BestFloatFormat()).To(Equal("%.1f"))
+			Expect(output.FormatFloats([]float64{1.25e-6, 2e-6}).BestFloatFormat()).To(Equal("%.2e"))
+		})
+
+		It("keeps the digits of every value in a series of mixed magnitudes", func() {
+			values := []float64{1e-6, 12345, 2.5}
+			floatFormat := output.FormatFloats(values).BestFloatFormat()
+
+			var formatted []string
+			for _, value := range values {
+				formatted = append(formatted, fmt.Sprintf(floatFormat, value))
+			}
+
+			Expect(formatted).To(Equal([]string{"1.0000e-06", "1.2345e+04", "2.5000e+00"}))
+		})
+
+		It("uses whole numbers when there are no finite values", func() {
+			Expect(output.FormatFloats([]float64{math.NaN()}).BestFloatFormat()).To(Equal("%.0f"))
+		})
+	})
 })
 
 func mktime(y int, mo time.Month, d int, h, m, s, ms int) time.Time {
@@ -433,6 +973,18 @@ func mktime(y int, mo time.Month, d int, h, m, s, ms int) time.Time {
 	)
 }
 
+// allSeries copies every series out of a range vector, as EachSeries reuses them.
+func allSeries(f *output.FormattedRangeVector) (result []output.FormattedSeries) {
+	f.EachSeries(func(series *output.FormattedSeries) {
+		result = append(result, output.FormattedSeries{
+			LabelValues: series.LabelValues,
+			Values:      append([]output.FormattedSamplePair(nil), series.Values...),
+		})
+	})
+
+	return
+}
+
 func fptr(f float64) *float64 {
 	return &f
 }
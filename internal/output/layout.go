@@ -0,0 +1,78 @@
+package output
+
+type RangeLayout string
+
+const (
+	RangeLayoutAuto       RangeLayout = "auto"
+	RangeLayoutTable      RangeLayout = "table"
+	RangeLayoutTransposed RangeLayout = "transposed"
+	RangeLayoutList       RangeLayout = "list"
+)
+
+// MaxAutoTableCells is the most values the auto layout will consider showing in a table. Choosing
+// between tables means formatting every value an extra time to measure it, and a transposed table
+// holds all of its cells until it's shown, so larger results go straight to a list.
+const MaxAutoTableCells = 50000
+
+// MeasuresRangeColumns returns whether a range vector of `seriesCount` series over `timeCount`
+// times needs its columns measured for `layout`: to choose between layouts in auto, or to split a
+// table into pages. Neither is needed if output isn't going to a terminal.
+func MeasuresRangeColumns(layout RangeLayout, seriesCount, timeCount, terminalWidth int) bool {
+	switch {
+	case terminalWidth == 0:
+		return false
+	case layout == RangeLayoutAuto:
+		return seriesCount*timeCount <= MaxAutoTableCells
+	}
+
+	return layout == RangeLayoutTable
+}
+
+// ChooseRangeLayout resolves the auto layout to a table if it's no wider than the terminal, the
+// table transposed if that is, and a list otherwise. A `terminalWidth` of 0 means output isn't
+// going to a terminal, where lists are always used. Other layouts are returned unchanged.
+func ChooseRangeLayout(layout RangeLayout, tableWidth, transposedWidth, terminalWidth int) RangeLayout {
+	if layout != RangeLayoutAuto {
+		return layout
+	}
+
+	switch {
+	case terminalWidth == 0:
+		return RangeLayoutList
+	case tableWidth <= terminalWidth:
+		return RangeLayoutTable
+	case transposedWidth <= terminalWidth:
+		return RangeLayoutTransposed
+	}
+
+	return RangeLayoutList
+}
+
+// ColumnPage is the columns from Start up to End shown by one of several stacked tables.
+type ColumnPage struct {
+	Start int
+	End   int
+}
+
+// PageColumns splits columns of the given `widths` into pages that each fit in `terminalWidth`
+// next to `fixedWidth` of columns repeated on every page. Each page has at least one column, even if
+// it doesn't fit.
+func PageColumns(fixedWidth int, widths []int, terminalWidth int) []ColumnPage {
+	pages := []ColumnPage{{Start: 0, End: 0}}
+	pageWidth := fixedWidth
+
+	for i, width := range widths {
+		page := &pages[len(pages)-1]
+
+		if page.End != page.Start && pageWidth+width > terminalWidth {
+			pages = append(pages, ColumnPage{Start: i, End: i})
+			page = &pages[len(pages)-1]
+			pageWidth = fixedWidth
+		}
+
+		page.End++
+		pageWidth += width
+	}
+
+	return pages
+}
@@ -0,0 +1,54 @@
+package output_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/output"
+)
+
+var _ = Describe("Layout", func() {
+	DescribeTable("ChooseRangeLayout",
+		func(layout output.RangeLayout, tableWidth, transposedWidth, terminalWidth int, expected output.RangeLayout) {
+			Expect(output.ChooseRangeLayout(layout, tableWidth, transposedWidth, terminalWidth)).To(Equal(expected))
+		},
+
+		Entry("uses a table when it fits", output.RangeLayoutAuto, 80, 40, 80, output.RangeLayoutTable),
+		Entry("transposes the table when only that fits", output.RangeLayoutAuto, 200, 60, 80, output.RangeLayoutTransposed),
+		Entry("uses a list when neither fits", output.RangeLayoutAuto, 200, 100, 80, output.RangeLayoutList),
+		Entry("uses a list when not writing to a terminal", output.RangeLayoutAuto, 10, 10, 0, output.RangeLayoutList),
+		Entry("keeps other layouts", output.RangeLayoutTable, 200, 100, 80, output.RangeLayoutTable),
+	)
+
+	DescribeTable("MeasuresRangeColumns",
+		func(layout output.RangeLayout, seriesCount, timeCount, terminalWidth int, expected bool) {
+			Expect(output.MeasuresRangeColumns(layout, seriesCount, timeCount, terminalWidth)).To(Equal(expected))
+		},
+
+		Entry("measures to choose a layout", output.RangeLayoutAuto, 10, 10, 80, true),
+		Entry("measures to page a table", output.RangeLayoutTable, 10, 10, 80, true),
+		Entry("doesn't measure other layouts", output.RangeLayoutTransposed, 10, 10, 80, false),
+		Entry("doesn't measure when not writing to a terminal", output.RangeLayoutAuto, 10, 10, 0, false),
+		Entry("doesn't measure too many values for a table", output.RangeLayoutAuto, 1000, 1000, 80, false),
+	)
+
+	DescribeTable("PageColumns",
+		func(fixedWidth int, widths []int, terminalWidth int, expected []output.ColumnPage) {
+			Expect(output.PageColumns(fixedWidth, widths, terminalWidth)).To(Equal(expected))
+		},
+
+		Entry("keeps columns that fit on one page",
+			10, []int{10, 10, 10}, 40,
+			[]output.ColumnPage{{Start: 0, End: 3}},
+		),
+		Entry("starts a new page when columns overflow",
+			10, []int{10, 10, 10, 10, 10}, 35,
+			[]output.ColumnPage{{Start: 0, End: 2}, {Start: 2, End: 4}, {Start: 4, End: 5}},
+		),
+		Entry("puts at least one column on each page",
+			30, []int{20, 20}, 40,
+			[]output.ColumnPage{{Start: 0, End: 1}, {Start: 1, End: 2}},
+		),
+	)
+})
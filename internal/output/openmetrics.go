@@ -0,0 +1,143 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+type openMetricsSeries struct {
+	Metric  model.Metric
+	Samples []model.SamplePair
+}
+
+// RenderOpenMetrics writes an instant or range vector in the OpenMetrics text format, with the
+// timestamp of every sample, so it can be backfilled with `promtool tsdb create-blocks-from
+// openmetrics`.
+func RenderOpenMetrics(value model.Value) error {
+	return WriteOpenMetrics(os.Stdout, value)
+}
+
+func WriteOpenMetrics(w io.Writer, value model.Value) error {
+	var series []openMetricsSeries
+
+	switch value.Type() {
+	case model.ValVector:
+		for _, sample := range value.(model.Vector) {
+			if sample.Histogram != nil {
+				return fmt.Errorf("cannot export native histogram %s", sample.Metric)
+			}
+
+			series = append(series, openMetricsSeries{
+				Metric: sample.Metric,
+				Samples: []model.SamplePair{
+					{Timestamp: sample.Timestamp, Value: sample.Value},
+				},
+			})
+		}
+	case model.ValMatrix:
+		for _, stream := range value.(model.Matrix) {
+			if len(stream.Histograms) != 0 {
+				return fmt.Errorf("cannot export native histogram %s", stream.Metric)
+			}
+
+			series = append(series, openMetricsSeries{
+				Metric:  stream.Metric,
+				Samples: stream.Values,
+			})
+		}
+	default:
+		return fmt.Errorf("cannot export a %s, only instant and range vectors", value.Type())
+	}
+
+	for _, s := range series {
+		if _, ok := s.Metric[model.MetricNameLabel]; !ok {
+			return fmt.Errorf("cannot export %s without a metric name", s.Metric)
+		}
+	}
+
+	// All the series of a metric family have to be next to each other
+	sort.SliceStable(series, func(i, j int) bool {
+		nameI, nameJ := series[i].Metric[model.MetricNameLabel], series[j].Metric[model.MetricNameLabel]
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+
+		return series[i].Metric.Before(series[j].Metric)
+	})
+
+	// Written as it goes, so huge results aren't held in memory twice
+	b := bufio.NewWriter(w)
+	lastName := model.LabelValue("")
+
+	for _, s := range series {
+		name := s.Metric[model.MetricNameLabel]
+		if name != lastName {
+			fmt.Fprintf(b, "# TYPE %s unknown\n", name)
+			lastName = name
+		}
+
+		labels := formatOpenMetricsLabels(s.Metric)
+		for _, sample := range s.Samples {
+			fmt.Fprintf(
+				b,
+				"%s%s %s %s\n",
+				name, labels,
+				formatOpenMetricsFloat(float64(sample.Value)),
+				sample.Timestamp,
+			)
+		}
+	}
+
+	b.WriteString("# EOF\n")
+
+	return b.Flush()
+}
+
+func formatOpenMetricsLabels(metric model.Metric) string {
+	var labelNames []string
+	for labelName := range metric {
+		if labelName != model.MetricNameLabel {
+			labelNames = append(labelNames, string(labelName))
+		}
+	}
+
+	if len(labelNames) == 0 {
+		return ""
+	}
+
+	sort.Strings(labelNames)
+
+	var labels []string
+	for _, labelName := range labelNames {
+		labels = append(labels, fmt.Sprintf(
+			"%s=\"%s\"",
+			labelName,
+			openMetricsEscaper.Replace(string(metric[model.LabelName(labelName)])),
+		))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatOpenMetricsFloat(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,79 @@
+package output_test
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pianohacker/quickprom/internal/output"
+)
+
+var _ = Describe("OpenMetrics", func() {
+	It("writes instant vectors grouped by metric name", func() {
+		var b bytes.Buffer
+
+		err := output.WriteOpenMetrics(&b, model.Vector{
+			{Metric: model.Metric{"__name__": "up", "job": "b"}, Value: 1, Timestamp: 1500},
+			{Metric: model.Metric{"__name__": "go_goroutines", "A": "a"}, Value: 12.5, Timestamp: 1500},
+			{Metric: model.Metric{"__name__": "up", "job": "a"}, Value: 0, Timestamp: 1500},
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.String()).To(Equal(`# TYPE go_goroutines unknown
+go_goroutines{A="a"} 12.5 1.5
+# TYPE up unknown
+up{job="a"} 0 1.5
+up{job="b"} 1 1.5
+# EOF
+`))
+	})
+
+	It("writes every sample of range vectors", func() {
+		var b bytes.Buffer
+
+		err := output.WriteOpenMetrics(&b, model.Matrix{
+			{
+				Metric: model.Metric{"__name__": "temp"},
+				Values: []model.SamplePair{
+					{Timestamp: 1000, Value: model.SampleValue(math.Inf(-1))},
+					{Timestamp: 2000, Value: model.SampleValue(math.NaN())},
+				},
+			},
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.String()).To(Equal(`# TYPE temp unknown
+temp -Inf 1
+temp NaN 2
+# EOF
+`))
+	})
+
+	It("escapes label values", func() {
+		var b bytes.Buffer
+
+		err := output.WriteOpenMetrics(&b, model.Vector{
+			{Metric: model.Metric{"__name__": "a", "path": "C:\\\"x\"\n"}, Value: 1, Timestamp: 1000},
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.String()).To(ContainSubstring(`a{path="C:\\\"x\"\n"} 1 1`))
+	})
+
+	It("requires metric names", func() {
+		err := output.WriteOpenMetrics(&bytes.Buffer{}, model.Vector{
+			{Metric: model.Metric{"job": "a"}, Value: 1},
+		})
+
+		Expect(err).To(MatchError(ContainSubstring("without a metric name")))
+	})
+
+	It("rejects scalars", func() {
+		err := output.WriteOpenMetrics(&bytes.Buffer{}, &model.Scalar{Value: 1})
+
+		Expect(err).To(HaveOccurred())
+	})
+})
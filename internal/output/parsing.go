@@ -0,0 +1,62 @@
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/common/model"
+)
+
+type rawJsonValue struct {
+	ResultType model.ValueType `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// ParseJson reads a query result, either as a full response from the Prometheus HTTP API or in the
+// form written by RenderJson.
+func ParseJson(contents []byte) (model.Value, error) {
+	var document struct {
+		rawJsonValue
+		Status string        `json:"status"`
+		Error  string        `json:"error"`
+		Data   *rawJsonValue `json:"data"`
+	}
+
+	err := json.Unmarshal(contents, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	rawValue := &document.rawJsonValue
+	if document.Status != "" {
+		if document.Status != "success" {
+			return nil, fmt.Errorf("response has status %s: %s", document.Status, document.Error)
+		}
+
+		if document.Data == nil {
+			return nil, errors.New("response has no data")
+		}
+
+		rawValue = document.Data
+	}
+
+	switch rawValue.ResultType {
+	case model.ValScalar:
+		var scalar model.Scalar
+		err = json.Unmarshal(rawValue.Result, &scalar)
+		return &scalar, err
+	case model.ValVector:
+		var vector model.Vector
+		err = json.Unmarshal(rawValue.Result, &vector)
+		return vector, err
+	case model.ValMatrix:
+		var matrix model.Matrix
+		err = json.Unmarshal(rawValue.Result, &matrix)
+		return matrix, err
+	case model.ValNone:
+		return nil, errors.New("no resultType found")
+	}
+
+	return nil, fmt.Errorf("cannot render a result of type %s", rawValue.ResultType)
+}
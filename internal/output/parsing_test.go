@@ -0,0 +1,63 @@
+package output_test
+
+import (
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pianohacker/quickprom/internal/output"
+)
+
+var _ = Describe("ParseJson", func() {
+	It("reads responses from the Prometheus API", func() {
+		value, err := output.ParseJson([]byte(`{
+			"status": "success",
+			"data": {
+				"resultType": "vector",
+				"result": [{"metric": {"job": "a"}, "value": [1.5, "2"]}]
+			}
+		}`))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal(model.Vector{
+			{Metric: model.Metric{"job": "a"}, Value: 2, Timestamp: 1500},
+		}))
+	})
+
+	It("reads the output of --json", func() {
+		value, err := output.ParseJson([]byte(`{
+			"resultType": "matrix",
+			"result": [{"metric": {"job": "a"}, "values": [[1, "2"], [2, "3"]]}]
+		}`))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal(model.Matrix{
+			{
+				Metric: model.Metric{"job": "a"},
+				Values: []model.SamplePair{
+					{Timestamp: 1000, Value: 2},
+					{Timestamp: 2000, Value: 3},
+				},
+			},
+		}))
+	})
+
+	It("reads scalars", func() {
+		value, err := output.ParseJson([]byte(`{"resultType": "scalar", "result": [1, "42"]}`))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal(&model.Scalar{Value: 42, Timestamp: 1000}))
+	})
+
+	It("returns the error of failed responses", func() {
+		_, err := output.ParseJson([]byte(`{"status": "error", "error": "parse error"}`))
+
+		Expect(err).To(MatchError(ContainSubstring("parse error")))
+	})
+
+	It("returns an error for other JSON", func() {
+		_, err := output.ParseJson([]byte(`{"a": 1}`))
+
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,14 +1,21 @@
 package output
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/xlab/termtables"
 	isatty "github.com/mattn/go-isatty"
+	runewidth "github.com/mattn/go-runewidth"
 	"github.com/prometheus/common/model"
+	promvalue "github.com/prometheus/prometheus/model/value"
+	"golang.org/x/term"
 )
 
 const TimeFormatWithTZ = "2006-01-02 15:04:05.000 MST"
@@ -41,7 +48,19 @@ type Renderable interface {
 }
 
 type RenderOptions struct {
-	RangeVectorAsTable bool
+	RangeLayout      RangeLayout
+	RangeVectorStats bool
+	RawBuckets       bool
+	// Width of the terminal output is going to, or 0 if it isn't going to a terminal
+	TerminalWidth int
+	// Choose the format of each range vector series, and of each value column of other results, on
+	// its own, rather than one for the whole result
+	SeparateScales bool
+	// Show exactly Precision digits after the decimal point
+	FixedPrecision bool
+	Precision      int
+	// Show this many significant figures, if not 0
+	SigFigs int
 }
 
 func FormatValue(value model.Value) Renderable {
@@ -57,7 +76,7 @@ func FormatValue(value model.Value) Renderable {
 	return nil
 }
 
-func (f *FormattedScalar) RenderText(_ *RenderOptions) {
+func (f *FormattedScalar) RenderText(opts *RenderOptions) {
 	fmt.Print("Scalar:")
 	if f.Empty {
 		fmt.Println(" (empty result)")
@@ -68,11 +87,15 @@ func (f *FormattedScalar) RenderText(_ *RenderOptions) {
 	fmt.Printf("  At: %s\n", f.Time.Format(TimeFormatWithTZ))
 
 	tw := getTableWriter([]interface{}{bold("value")})
-	tw.AddRow(fmt.Sprintf("%g", f.Value))
+	if opts.FixedPrecision || opts.SigFigs != 0 {
+		tw.AddRow(formatFloat(f.FloatFormat(opts), f.Value))
+	} else {
+		tw.AddRow(fmt.Sprintf("%g", f.Value))
+	}
 	fmt.Print(tw.Render())
 }
 
-func (f *FormattedInstantVector) RenderText(_ *RenderOptions) {
+func (f *FormattedInstantVector) RenderText(opts *RenderOptions) {
 	fmt.Print("Instant vector:")
 	if f.Empty {
 		fmt.Println(" (empty result)")
@@ -80,10 +103,30 @@ func (f *FormattedInstantVector) RenderText(_ *RenderOptions) {
 	}
 	fmt.Println()
 
-	fmt.Printf("  At: %s\n", f.Time.Format(TimeFormatWithTZ))
+	var timestampFormat string
+	if f.SampleTimes {
+		var times []time.Time
+		for _, sample := range f.Samples {
+			times = append(times, sample.Time)
+		}
+
+		sharedDateParts := SharedDateParts(times)
+		if sharedDateParts.Date {
+			fmt.Printf("  All on date: %s\n", times[0].Format(TimeFormatDateOnly))
+		}
+
+		timestampFormat = getTimestampFormat(sharedDateParts)
+	} else {
+		fmt.Printf("  At: %s\n", f.Time.Format(TimeFormatWithTZ))
+	}
 
 	outputCommonLabels("samples", f.CommonLabels)
 
+	if f.Histograms != nil && f.ComparisonNames == nil && !opts.RawBuckets {
+		f.renderHistograms(opts)
+		return
+	}
+
 	// Value column
 	var header []interface{}
 
@@ -91,10 +134,18 @@ func (f *FormattedInstantVector) RenderText(_ *RenderOptions) {
 		header = append(header, bold(labelName))
 	}
 
+	if f.SampleTimes {
+		header = append(header, rightAlignedCell(bold("time")))
+	}
+
 	header = append(header, bold("value"))
 
+	for _, comparisonName := range f.ComparisonNames {
+		header = append(header, rightAlignedCell(bold(comparisonName)))
+	}
+
 	tw := getTableWriter(header)
-	floatFormat := f.BestFloatFormat()
+	floatFormat, comparisonFormats := f.columnFormats(opts)
 
 	for _, sample := range f.Samples {
 		var row []interface{}
@@ -103,8 +154,270 @@ func (f *FormattedInstantVector) RenderText(_ *RenderOptions) {
 			row = append(row, labelValue)
 		}
 
+		if f.SampleTimes {
+			row = append(row, rightAlignedCell(sample.Time.Format(timestampFormat)))
+		}
+
 		row = append(row, rightAlignedCell(
-			fmt.Sprintf(floatFormat, sample.Value),
+			formatSampleValue(floatFormat, sample.Value, sample.Histogram),
+		))
+
+		for i, comparison := range sample.Comparisons {
+			if comparison == nil {
+				row = append(row, "")
+			} else {
+				row = append(row, rightAlignedCell(
+					formatFloat(comparisonFormats[i], *comparison),
+				))
+			}
+		}
+
+		tw.AddRow(row...)
+	}
+
+	fmt.Print(tw.Render())
+}
+
+// columnFormats returns the formats of the value column and of each comparison column.
+func (f *FormattedInstantVector) columnFormats(opts *RenderOptions) (string, []string) {
+	floatFormat := f.FloatFormat(opts)
+	comparisonFormats := make([]string, len(f.ComparisonNames))
+
+	if !opts.SeparateScales {
+		for i := range comparisonFormats {
+			comparisonFormats[i] = floatFormat
+		}
+
+		return floatFormat, comparisonFormats
+	}
+
+	comparisonValues := make([][]float64, len(f.ComparisonNames))
+	for _, sample := range f.Samples {
+		for i, comparison := range sample.Comparisons {
+			if comparison != nil {
+				comparisonValues[i] = append(comparisonValues[i], *comparison)
+			}
+		}
+	}
+
+	for i, values := range comparisonValues {
+		comparisonFormats[i] = FormatFloats(values).FloatFormat(opts)
+	}
+
+	return FormatFloats(sampleValues(f.Samples)).FloatFormat(opts), comparisonFormats
+}
+
+func sampleValues(samples []FormattedSample) []float64 {
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		values[i] = sample.Value
+	}
+
+	return values
+}
+
+const histogramBarWidth = 40
+
+func (f *FormattedInstantVector) renderHistograms(opts *RenderOptions) {
+	floatFormat := f.FloatFormat(opts)
+
+	for _, histogram := range f.Histograms {
+		fmt.Println()
+
+		if len(f.HistogramLabels) != 0 {
+			for i, labelName := range f.HistogramLabels {
+				if i != 0 {
+					fmt.Print(", ")
+				}
+				fmt.Printf("%s %s", bold(labelName+":"), histogram.LabelValues[i])
+			}
+			fmt.Println(":")
+		}
+
+		fmt.Printf("  %s "+floatFormat, bold("Count:"), histogram.Count())
+		if histogram.Native {
+			fmt.Printf("  %s %g", bold("Sum:"), histogram.Sum)
+		}
+		for _, q := range []float64{0.5, 0.9, 0.99} {
+			fmt.Printf("  %s %s", bold(fmt.Sprintf("p%g:", q*100)), formatQuantile(histogram.Quantile(q)))
+		}
+		fmt.Println()
+
+		maxBucketCount := 0.0
+		for i := range histogram.Buckets {
+			maxBucketCount = math.Max(maxBucketCount, histogram.BucketCount(i))
+		}
+
+		bucketHeader := model.BucketLabel
+		if histogram.Native {
+			bucketHeader = "bucket"
+		}
+
+		tw := getTableWriter([]interface{}{
+			rightAlignedCell(bold(string(bucketHeader))),
+			rightAlignedCell(bold("count")),
+			rightAlignedCell(bold("cumulative")),
+			bold("distribution"),
+		})
+
+		for i, bucket := range histogram.Buckets {
+			bucketCount := histogram.BucketCount(i)
+
+			bar := ""
+			if maxBucketCount > 0 && bucketCount > 0 {
+				bar = strings.Repeat("█", int(math.Ceil(bucketCount/maxBucketCount*histogramBarWidth)))
+			}
+
+			tw.AddRow(
+				rightAlignedCell(bucket.Label),
+				rightAlignedCell(formatFloat(floatFormat, bucketCount)),
+				rightAlignedCell(formatFloat(floatFormat, bucket.Cumulative)),
+				bar,
+			)
+		}
+
+		fmt.Print(tw.Render())
+	}
+}
+
+// formatSampleValue formats a float value, or summarizes a native histogram.
+func formatSampleValue(floatFormat string, value float64, histogram *FormattedHistogram) string {
+	if histogram == nil {
+		return formatFloat(floatFormat, value)
+	}
+
+	return fmt.Sprintf("count: %s, sum: %g", formatFloat(floatFormat, histogram.Count()), histogram.Sum)
+}
+
+// formatFloat formats a value with a format from BestFloatFormat, spelling out values that aren't
+// numbers the same way Prometheus does. Stale markers, which remote-write senders send when a series
+// disappears, are a particular NaN.
+func formatFloat(floatFormat string, value float64) string {
+	switch {
+	case promvalue.IsStaleNaN(value):
+		return "stale"
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return fmt.Sprintf(floatFormat, value)
+}
+
+func formatQuantile(value float64) string {
+	if math.IsNaN(value) {
+		return "n/a"
+	}
+
+	return fmt.Sprintf("%.4g", value)
+}
+
+func (f *FormattedDiff) RenderText(opts *RenderOptions) {
+	fmt.Print("Diff:")
+	if f.Empty {
+		fmt.Println(" (empty result)")
+		return
+	}
+	fmt.Println()
+
+	fmt.Printf("  Before: %s\n", f.BeforeName)
+	fmt.Printf("  After: %s\n", f.AfterName)
+
+	outputCommonLabels("samples", f.CommonLabels)
+
+	floatFormat := f.FloatFormat(opts)
+	beforeFormat, afterFormat, changeFormat := floatFormat, floatFormat, floatFormat
+
+	if opts.SeparateScales {
+		var before, after, change []float64
+		for _, c := range f.Changes {
+			before = append(before, c.Before)
+			after = append(after, c.After)
+			change = append(change, c.Change())
+		}
+
+		beforeFormat = FormatFloats(before).FloatFormat(opts)
+		afterFormat = FormatFloats(after).FloatFormat(opts)
+		changeFormat = FormatFloats(change).FloatFormat(opts)
+	}
+
+	changeFormat = strings.Replace(changeFormat, "%", "%+", 1)
+
+	if len(f.Changes) != 0 {
+		var header []interface{}
+
+		for _, labelName := range f.VaryingLabels {
+			header = append(header, bold(labelName))
+		}
+
+		header = append(header, bold("before"), bold("after"), bold("change"), bold("% change"))
+
+		tw := getTableWriter(header)
+
+		for _, change := range f.Changes {
+			var row []interface{}
+
+			for _, labelValue := range change.LabelValues {
+				row = append(row, labelValue)
+			}
+
+			percentChange := "n/a"
+			if percent, ok := change.PercentChange(); ok {
+				percentChange = fmt.Sprintf("%+.1f%%", percent)
+			}
+
+			row = append(row,
+				rightAlignedCell(formatFloat(beforeFormat, change.Before)),
+				rightAlignedCell(formatFloat(afterFormat, change.After)),
+				rightAlignedCell(formatFloat(changeFormat, change.Change())),
+				rightAlignedCell(percentChange),
+			)
+
+			tw.AddRow(row...)
+		}
+
+		fmt.Print(tw.Render())
+	}
+
+	f.renderOnlyOneSide("Only before:", f.OnlyBefore, opts)
+	f.renderOnlyOneSide("Only after:", f.OnlyAfter, opts)
+}
+
+func (f *FormattedDiff) renderOnlyOneSide(title string, samples []FormattedSample, opts *RenderOptions) {
+	if len(samples) == 0 {
+		return
+	}
+
+	floatFormat := f.FloatFormat(opts)
+	if opts.SeparateScales {
+		floatFormat = FormatFloats(sampleValues(samples)).FloatFormat(opts)
+	}
+
+	fmt.Println()
+	fmt.Println(title)
+
+	var header []interface{}
+
+	for _, labelName := range f.VaryingLabels {
+		header = append(header, bold(labelName))
+	}
+
+	header = append(header, bold("value"))
+
+	tw := getTableWriter(header)
+
+	for _, sample := range samples {
+		var row []interface{}
+
+		for _, labelValue := range sample.LabelValues {
+			row = append(row, labelValue)
+		}
+
+		row = append(row, rightAlignedCell(
+			formatFloat(floatFormat, sample.Value),
 		))
 
 		tw.AddRow(row...)
@@ -137,59 +450,293 @@ func (f *FormattedRangeVector) RenderText(opts *RenderOptions) {
 
 	timestampFormat := getTimestampFormat(sharedDateParts)
 
-	if opts.RangeVectorAsTable {
-		f.renderRangeTable(timestampFormat)
+	if opts.RangeVectorStats {
+		f.renderRangeStats(opts)
+		return
+	}
+
+	layout := ChooseRangeLayout(opts.RangeLayout, 0, 0, 0)
+	pages := []ColumnPage{{Start: 0, End: len(f.SeenTimes)}}
+
+	// Without measuring, auto falls back to a list
+	if MeasuresRangeColumns(opts.RangeLayout, f.SeriesCount(), len(f.SeenTimes), opts.TerminalWidth) {
+		widths := f.measureRangeColumns(timestampFormat, opts)
+
+		layout = ChooseRangeLayout(opts.RangeLayout, widths.tableWidth(), widths.transposedWidth(), opts.TerminalWidth)
+		pages = PageColumns(sum(widths.labels), widths.times, opts.TerminalWidth)
+	}
+
+	switch layout {
+	case RangeLayoutTable:
+		f.renderRangeTable(timestampFormat, pages, opts)
+	case RangeLayoutTransposed:
+		f.renderRangeTransposed(timestampFormat, opts)
+	default:
+		f.renderRangeList(timestampFormat, opts)
+	}
+}
+
+// rangeColumnWidths holds the width of each column of a range vector's table, including padding.
+type rangeColumnWidths struct {
+	labels []int
+	times  []int
+	// Columns of the transposed table: the label names and times heading its rows, then each series
+	rowHeaders int
+	series     []int
+}
+
+func (w *rangeColumnWidths) tableWidth() int {
+	return sum(w.labels) + sum(w.times)
+}
+
+func (w *rangeColumnWidths) transposedWidth() int {
+	return w.rowHeaders + sum(w.series)
+}
+
+func (f *FormattedRangeVector) measureRangeColumns(timestampFormat string, opts *RenderOptions) *rangeColumnWidths {
+	widths := &rangeColumnWidths{
+		labels: make([]int, len(f.VaryingLabels)),
+		times:  make([]int, len(f.SeenTimes)),
+	}
+
+	for i, labelName := range f.VaryingLabels {
+		widths.labels[i] = cellWidth(labelName)
+		widths.rowHeaders = max(widths.rowHeaders, widths.labels[i])
+	}
+
+	for i, seenTime := range f.SeenTimes {
+		widths.times[i] = cellWidth(seenTime.Format(timestampFormat))
+		widths.rowHeaders = max(widths.rowHeaders, widths.times[i])
+	}
+
+	f.EachSeries(func(series *FormattedSeries) {
+		seriesWidth := 0
+		if len(f.VaryingLabels) == 0 {
+			seriesWidth = cellWidth("value")
+		}
+
+		for i, labelValue := range series.LabelValues {
+			widths.labels[i] = max(widths.labels[i], cellWidth(labelValue))
+			seriesWidth = max(seriesWidth, cellWidth(labelValue))
+		}
+
+		for i, cell := range f.seriesCells(series, opts) {
+			widths.times[i] = max(widths.times[i], cellWidth(cell))
+			seriesWidth = max(seriesWidth, cellWidth(cell))
+		}
+
+		widths.series = append(widths.series, seriesWidth)
+	})
+
+	return widths
+}
+
+// cellWidth returns the width of a table cell containing `s`, which termtables pads with a space on
+// either side.
+func cellWidth(s string) int {
+	return runewidth.StringWidth(s) + 2
+}
+
+func sum(values []int) (total int) {
+	for _, value := range values {
+		total += value
+	}
+
+	return
+}
+
+// seriesCells formats the value of `series` at each of the range vector's times, leaving times
+// without a value blank.
+func (f *FormattedRangeVector) seriesCells(series *FormattedSeries, opts *RenderOptions) []string {
+	floatFormat := f.seriesFormat(series, opts).FloatFormat(opts)
+	samples := f.CollateByTime(series)
+
+	cells := make([]string, len(samples))
+	for i, sample := range samples {
+		if sample != nil {
+			cells[i] = formatSampleValue(floatFormat, sample.Value, sample.Histogram)
+		}
+	}
+
+	return cells
+}
+
+// renderRangeTable shows a table with a row for each series and a column for each time, split into
+// stacked tables for each of `pages` of times.
+func (f *FormattedRangeVector) renderRangeTable(timestampFormat string, pages []ColumnPage, opts *RenderOptions) {
+	tables := make([]*termtables.Table, len(pages))
+
+	for i, page := range pages {
+		var header []interface{}
+
+		for _, labelName := range f.VaryingLabels {
+			header = append(header, bold(labelName))
+		}
+
+		for _, seenTime := range f.SeenTimes[page.Start:page.End] {
+			header = append(header, rightAlignedCell(
+				bold(seenTime.Format(timestampFormat)),
+			))
+		}
+
+		tables[i] = getTableWriter(header)
+	}
+
+	f.EachSeries(func(series *FormattedSeries) {
+		cells := f.seriesCells(series, opts)
+
+		for i, page := range pages {
+			var row []interface{}
+
+			for _, labelValue := range series.LabelValues {
+				row = append(row, labelValue)
+			}
+
+			for _, cell := range cells[page.Start:page.End] {
+				if cell == "" {
+					row = append(row, "")
+				} else {
+					row = append(row, rightAlignedCell(cell))
+				}
+			}
+
+			tables[i].AddRow(row...)
+		}
+	})
+
+	// Each table starts with a blank line, which separates it from the last
+	for _, tw := range tables {
+		fmt.Print(tw.Render())
+	}
+}
+
+// renderRangeTransposed shows a table with a column for each series, headed by its labels, and a
+// row for each time.
+func (f *FormattedRangeVector) renderRangeTransposed(timestampFormat string, opts *RenderOptions) {
+	labelRows := make([][]interface{}, len(f.VaryingLabels))
+	for i, labelName := range f.VaryingLabels {
+		labelRows[i] = []interface{}{bold(labelName)}
+	}
+
+	var columns [][]string
+
+	f.EachSeries(func(series *FormattedSeries) {
+		for i, labelValue := range series.LabelValues {
+			labelRows[i] = append(labelRows[i], rightAlignedCell(bold(labelValue)))
+		}
+
+		columns = append(columns, f.seriesCells(series, opts))
+	})
+
+	// Only the first label can be the header, so the others follow as rows styled the same way
+	var header []interface{}
+	if len(labelRows) == 0 {
+		header = []interface{}{""}
+		for range columns {
+			header = append(header, rightAlignedCell(bold("value")))
+		}
 	} else {
-		f.renderRangeList(timestampFormat)
+		header = labelRows[0]
+	}
+
+	tw := getTableWriter(header)
+	for i := 1; i < len(labelRows); i++ {
+		tw.AddRow(labelRows[i]...)
+	}
+
+	for i, seenTime := range f.SeenTimes {
+		row := []interface{}{seenTime.Format(timestampFormat)}
+
+		for _, column := range columns {
+			if column[i] == "" {
+				row = append(row, "")
+			} else {
+				row = append(row, rightAlignedCell(column[i]))
+			}
+		}
+
+		tw.AddRow(row...)
 	}
+
+	fmt.Print(tw.Render())
+}
+
+// seriesFormat returns how to format `series`: on its own with SeparateScales, or like the rest of
+// the range vector otherwise.
+func (f *FormattedRangeVector) seriesFormat(series *FormattedSeries, opts *RenderOptions) *FormattedValue {
+	if !opts.SeparateScales {
+		return &f.FormattedValue
+	}
+
+	values := make([]float64, len(series.Values))
+	for i, sample := range series.Values {
+		values[i] = sample.Value
+	}
+
+	return FormatFloats(values)
 }
 
-func (f *FormattedRangeVector) renderRangeTable(timestampFormat string) {
+func (f *FormattedRangeVector) renderRangeStats(opts *RenderOptions) {
 	var header []interface{}
 
 	for _, labelName := range f.VaryingLabels {
 		header = append(header, bold(labelName))
 	}
 
-	for _, seenTime := range f.SeenTimes {
-		header = append(header, rightAlignedCell(
-			bold(seenTime.Format(timestampFormat)),
-		))
+	for _, statName := range []string{"count", "first", "last", "min", "max", "mean", "median", "p95", "stddev"} {
+		header = append(header, rightAlignedCell(bold(statName)))
 	}
 
 	tw := getTableWriter(header)
 
-	collatedValues := f.CollateSeriesValuesByTime()
-	floatFormat := f.BestFloatFormat()
+	f.EachSeries(func(series *FormattedSeries) {
+		seriesValue := f.seriesFormat(series, opts)
+		floatFormat := seriesValue.FloatFormat(opts)
+
+		// Averages and interpolated values rarely land on the same digits as the original values, so
+		// they get a couple of extra digits
+		derivedValue := *seriesValue
+		derivedValue.MaxValueFracLength += 2
+		derivedFloatFormat := derivedValue.FloatFormat(opts)
 
-	for i, series := range f.Series {
 		var row []interface{}
 
 		for _, labelValue := range series.LabelValues {
 			row = append(row, labelValue)
 		}
 
-		for _, value := range collatedValues[i] {
-			if value == nil {
-				row = append(row, "")
-			} else {
-				row = append(row, rightAlignedCell(
-					fmt.Sprintf(floatFormat, *value),
-				))
-			}
+		stats := series.Stats()
+		count := fmt.Sprintf("%d", stats.Count)
+		if stats.NaNCount != 0 {
+			count = fmt.Sprintf("%d (%d NaN)", stats.Count, stats.NaNCount)
+		}
+		row = append(row, rightAlignedCell(count))
+
+		if stats.Count != 0 {
+			row = append(row,
+				rightAlignedCell(formatFloat(floatFormat, stats.First)),
+				rightAlignedCell(formatFloat(floatFormat, stats.Last)),
+				rightAlignedCell(formatFloat(floatFormat, stats.Min)),
+				rightAlignedCell(formatFloat(floatFormat, stats.Max)),
+				rightAlignedCell(formatFloat(derivedFloatFormat, stats.Mean)),
+				rightAlignedCell(formatFloat(derivedFloatFormat, stats.Median)),
+				rightAlignedCell(formatFloat(derivedFloatFormat, stats.P95)),
+				rightAlignedCell(formatFloat(derivedFloatFormat, stats.Stddev)),
+			)
 		}
 
 		tw.AddRow(row...)
-	}
+	})
 
 	fmt.Print(tw.Render())
 }
 
-func (f *FormattedRangeVector) renderRangeList(timestampFormat string) {
+func (f *FormattedRangeVector) renderRangeList(timestampFormat string, opts *RenderOptions) {
 	fmt.Println()
-	floatFormat := f.BestFloatFormat()
 
-	for _, series := range f.Series {
+	f.EachSeries(func(series *FormattedSeries) {
+		floatFormat := f.seriesFormat(series, opts).FloatFormat(opts)
+
 		for i, labelName := range f.VaryingLabels {
 			if i != 0 {
 				fmt.Print(", ")
@@ -200,9 +747,13 @@ func (f *FormattedRangeVector) renderRangeList(timestampFormat string) {
 
 		for _, sample := range series.Values {
 			fmt.Printf("    %s: ", sample.Time.Format(timestampFormat))
-			fmt.Printf(floatFormat+"\n", sample.Value)
+			fmt.Println(formatSampleValue(floatFormat, sample.Value, sample.Histogram))
 		}
-	}
+	})
+}
+
+func RenderHeading(name, query string) {
+	fmt.Printf("%s %s\n", bold(name+":"), query)
 }
 
 func outputCommonLabels(subValueType string, commonLabels map[string]string) {
@@ -244,6 +795,30 @@ func bold(s string) string {
 
 var outputIsATty = isatty.IsTerminal(os.Stdout.Fd())
 
+// TerminalWidth returns the width of the terminal output is going to, or 0 if it isn't a terminal.
+func TerminalWidth() int {
+	if !outputIsATty {
+		return 0
+	}
+
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+
+	return width
+}
+
+// ClearScreen clears the terminal before redrawing, or just leaves a blank line if the output isn't
+// a terminal.
+func ClearScreen() {
+	if outputIsATty {
+		fmt.Print("\x1b[H\x1b[2J")
+	} else {
+		fmt.Println()
+	}
+}
+
 func rightAlignedCell(s string) *termtables.Cell {
 	return termtables.CreateCell(
 		s,
@@ -256,14 +831,123 @@ func rightAlignedCell(s string) *termtables.Cell {
 type jsonValue struct {
 	ResultType model.ValueType `json:"resultType"`
 	Result     model.Value     `json:"result"`
+	Warnings   []string        `json:"warnings,omitempty"`
 }
 
-func RenderJson(value model.Value) error {
+func RenderJson(value model.Value, warnings []string) error {
+	if matrix, ok := value.(model.Matrix); ok {
+		return renderMatrixJson(matrix, warnings)
+	}
+
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
 
 	return enc.Encode(&jsonValue{
 		ResultType: value.Type(),
 		Result:     value,
+		Warnings:   warnings,
 	})
 }
+
+// renderMatrixJson writes the same JSON as RenderJson one series at a time, as encoding a huge
+// matrix all at once holds the whole output in memory.
+func renderMatrixJson(matrix model.Matrix, warnings []string) error {
+	w := bufio.NewWriter(os.Stdout)
+
+	w.WriteString("{\n  \"resultType\": \"matrix\",\n  \"result\": [")
+	for i, series := range matrix {
+		if i != 0 {
+			w.WriteString(",")
+		}
+
+		encoded, err := json.MarshalIndent(series, "    ", "  ")
+		if err != nil {
+			return err
+		}
+
+		w.WriteString("\n    ")
+		w.Write(encoded)
+	}
+
+	if len(matrix) != 0 {
+		w.WriteString("\n  ")
+	}
+	w.WriteString("]")
+
+	if len(warnings) != 0 {
+		encoded, err := json.MarshalIndent(warnings, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		w.WriteString(",\n  \"warnings\": ")
+		w.Write(encoded)
+	}
+	w.WriteString("\n}\n")
+
+	return w.Flush()
+}
+
+type NamedValue struct {
+	Name     string
+	Query    string
+	Value    model.Value
+	Warnings []string
+	Err      error
+}
+
+type jsonNamedValue struct {
+	Name       string          `json:"name"`
+	Query      string          `json:"query"`
+	ResultType model.ValueType `json:"resultType,omitempty"`
+	Result     model.Value     `json:"result,omitempty"`
+	Warnings   []string        `json:"warnings,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+func RenderNamedJson(values []NamedValue) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	jsonValues := []jsonNamedValue{}
+	for _, value := range values {
+		jsonValue := jsonNamedValue{
+			Name:     value.Name,
+			Query:    value.Query,
+			Warnings: value.Warnings,
+		}
+
+		if value.Err != nil {
+			jsonValue.Error = value.Err.Error()
+		} else {
+			jsonValue.ResultType = value.Value.Type()
+			jsonValue.Result = value.Value
+		}
+
+		jsonValues = append(jsonValues, jsonValue)
+	}
+
+	return enc.Encode(jsonValues)
+}
+
+type jsonDiffSample struct {
+	Metric model.Metric       `json:"metric"`
+	Before *model.SampleValue `json:"before"`
+	After  *model.SampleValue `json:"after"`
+}
+
+func RenderDiffJson(before, after model.Vector) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	diffSamples := []jsonDiffSample{}
+	for _, joined := range joinVectors(before, after) {
+		diffSamples = append(diffSamples, jsonDiffSample{
+			Metric: joined.Metric,
+			Before: joined.Values[0],
+			After:  joined.Values[1],
+		})
+	}
+
+	return enc.Encode(diffSamples)
+}
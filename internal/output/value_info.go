@@ -1,22 +1,31 @@
 package output
 
 import (
-	"fmt"
+	"bytes"
 	"math"
-	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/common/model"
 )
 
 type ValueInfo struct {
-	labelInfo          labelInfoMap
-	length             int
-	seenTimestamps     map[model.Time]struct{}
-	MaxValueExp        int
-	MinValueExp        int
+	labelInfo      labelInfoMap
+	length         int
+	seenTimestamps map[model.Time]struct{}
+	MaxValueExp    int
+	MinValueExp    int
+	// Digits needed after the decimal point in the notation the values are shown in
 	MaxValueFracLength int
+	// The digits needed after the decimal point written out in full, and with an exponent
+	maxFixedFracLength    int
+	maxMantissaFracLength int
+	formatBuffer          []byte
+	// Whether every sample is a bucket of a classic histogram, and the buckets have more than one
+	// upper bound
+	ClassicHistogram bool
 }
 
 type labelInfoMap map[string]*labelInfo
@@ -40,6 +49,24 @@ func ScalarInfo(scalar *model.Scalar) *ValueInfo {
 	}
 
 	v.addValue(scalar.Value)
+	v.normalizeValueInfo()
+
+	return v
+}
+
+// FloatsInfo returns the information needed to format just `values`, such as a single series or
+// column of a larger result.
+func FloatsInfo(values []float64) *ValueInfo {
+	v := &ValueInfo{
+		MinValueExp:        MaxInt,
+		MaxValueExp:        MinInt,
+		MaxValueFracLength: 0,
+	}
+
+	for _, value := range values {
+		v.addValue(model.SampleValue(value))
+	}
+	v.normalizeValueInfo()
 
 	return v
 }
@@ -52,11 +79,15 @@ func InstantVectorInfo(instantVector model.Vector) *ValueInfo {
 		MaxValueFracLength: 0,
 	}
 
+	allBuckets := true
 	for _, sample := range instantVector {
 		v.addMetric(sample.Metric)
-		v.addValue(sample.Value)
+		v.addSampleValue(sample.Value, sample.Histogram)
+
+		allBuckets = allBuckets && isBucketMetric(sample.Metric)
 	}
 	v.length = len(instantVector)
+	v.ClassicHistogram = v.length > 0 && allBuckets && !v.isLabelCommon(model.BucketLabel)
 
 	if v.length > 0 {
 		v.seenTimestamps = map[model.Time]struct{}{
@@ -68,21 +99,45 @@ func InstantVectorInfo(instantVector model.Vector) *ValueInfo {
 	return v
 }
 
+// SeriesSource calls `seriesFunc` with each series of a range vector in turn, so a range vector can be
+// read one series at a time rather than held whole. It may be called more than once, and gives the
+// same series each time.
+type SeriesSource func(seriesFunc func(series *model.SampleStream))
+
+// MatrixSource returns the series of a matrix that's already in memory.
+func MatrixSource(m model.Matrix) SeriesSource {
+	return func(seriesFunc func(series *model.SampleStream)) {
+		for _, series := range m {
+			seriesFunc(series)
+		}
+	}
+}
+
 func RangeVectorInfo(rangeVector model.Matrix) *ValueInfo {
+	return RangeSeriesInfo(MatrixSource(rangeVector))
+}
+
+// RangeSeriesInfo reads the series of a range vector once, keeping only their labels, times and the
+// scale of their values.
+func RangeSeriesInfo(source SeriesSource) *ValueInfo {
 	v := &ValueInfo{
 		labelInfo:      make(labelInfoMap),
 		seenTimestamps: map[model.Time]struct{}{},
 	}
 
-	for _, series := range rangeVector {
+	source(func(series *model.SampleStream) {
 		v.addMetric(series.Metric)
 		for _, sample := range series.Values {
 			v.addTimestamp(sample.Timestamp)
 			v.addValue(sample.Value)
 		}
-	}
+		for _, sample := range series.Histograms {
+			v.addTimestamp(sample.Timestamp)
+			v.addHistogram(sample.Histogram)
+		}
+		v.length++
+	})
 	v.normalizeValueInfo()
-	v.length = len(rangeVector)
 
 	return v
 }
@@ -105,11 +160,28 @@ func (v *ValueInfo) addMetric(metric model.Metric) {
 	}
 }
 
-var fracMatcher = regexp.MustCompile(`^\d+\.(\d+)`)
+func (v *ValueInfo) addSampleValue(sampleValue model.SampleValue, histogram *model.SampleHistogram) {
+	if histogram != nil {
+		v.addHistogram(histogram)
+	} else {
+		v.addValue(sampleValue)
+	}
+}
+
+// addHistogram includes the count of the histogram and each of its buckets, as those are what end
+// up being shown.
+func (v *ValueInfo) addHistogram(histogram *model.SampleHistogram) {
+	v.addValue(model.SampleValue(histogram.Count))
+	for _, bucket := range histogram.Buckets {
+		v.addValue(model.SampleValue(bucket.Count))
+	}
+}
 
 func (v *ValueInfo) addValue(sampleValue model.SampleValue) {
-	val := float64(sampleValue)
-	if val == 0 {
+	// Only the magnitude of a value decides how it's formatted. NaN and infinite values are spelled
+	// out rather than formatted, so they're left out.
+	val := math.Abs(float64(sampleValue))
+	if val == 0 || math.IsNaN(val) || math.IsInf(val, 0) {
 		return
 	}
 
@@ -123,16 +195,64 @@ func (v *ValueInfo) addValue(sampleValue model.SampleValue) {
 		v.MaxValueExp = valExp
 	}
 
-	shortestFormat := fmt.Sprintf("%g", val)
-	fracMatch := fracMatcher.FindStringSubmatchIndex(shortestFormat)
-
-	if fracMatch != nil {
-		fracLength := fracMatch[3] - fracMatch[2]
+	fixedFracLength, mantissaFracLength := v.fracLengths(val)
+	if fixedFracLength > v.maxFixedFracLength {
+		v.maxFixedFracLength = fixedFracLength
+	}
+	if mantissaFracLength > v.maxMantissaFracLength {
+		v.maxMantissaFracLength = mantissaFracLength
+	}
+}
 
-		if fracLength > v.MaxValueFracLength {
-			v.MaxValueFracLength = fracLength
+// fracLengths returns the number of digits after the decimal point when the positive, finite `val`
+// is written as briefly as possible, both in full and with an exponent. Results can have millions of
+// values, so whole numbers are worked out without formatting them, and others are formatted into a
+// reused buffer.
+func (v *ValueInfo) fracLengths(val float64) (fixed int, mantissa int) {
+	// Every whole number below 2^53 is exact, so its shortest form is just its digits
+	if val < 1<<53 && val == math.Trunc(val) {
+		significantDigits := 0
+		for n := uint64(val); n != 0; n /= 10 {
+			if significantDigits != 0 || n%10 != 0 {
+				significantDigits++
+			}
 		}
+
+		return 0, significantDigits - 1
+	}
+
+	v.formatBuffer = strconv.AppendFloat(v.formatBuffer[:0], val, 'e', -1, 64)
+
+	exponentStart := bytes.IndexByte(v.formatBuffer, 'e')
+	if exponentStart > 1 {
+		// Everything between the point and the exponent
+		mantissa = exponentStart - 2
+	}
+
+	exponent, _ := strconv.Atoi(string(v.formatBuffer[exponentStart+1:]))
+	if mantissa > exponent {
+		fixed = mantissa - exponent
 	}
+
+	return fixed, mantissa
+}
+
+// isBucketMetric checks whether a metric looks like a histogram bucket. Names are only checked if
+// they're present, as functions like rate() drop them.
+func isBucketMetric(metric model.Metric) bool {
+	upperBound, ok := metric[model.BucketLabel]
+	if !ok {
+		return false
+	}
+
+	_, err := strconv.ParseFloat(string(upperBound), 64)
+	if err != nil {
+		return false
+	}
+
+	name, ok := metric[model.MetricNameLabel]
+
+	return !ok || strings.HasSuffix(string(name), "_bucket")
 }
 
 func (v *ValueInfo) normalizeValueInfo() {
@@ -140,6 +260,18 @@ func (v *ValueInfo) normalizeValueInfo() {
 		v.MinValueExp = 0
 		v.MaxValueExp = 0
 	}
+
+	if UsesExponent(v.MinValueExp, v.MaxValueExp) {
+		v.MaxValueFracLength = v.maxMantissaFracLength
+	} else {
+		v.MaxValueFracLength = v.maxFixedFracLength
+	}
+}
+
+// UsesExponent returns whether values with exponents between `minExp` and `maxExp` are shown with an
+// exponent, rather than written out in full.
+func UsesExponent(minExp, maxExp int) bool {
+	return minExp <= -4 || maxExp >= 6
 }
 
 func (v *ValueInfo) addTimestamp(timestamp model.Time) {
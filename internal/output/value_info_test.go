@@ -1,11 +1,14 @@
 package output_test
 
 import (
+	"math"
 	"time"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/value"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/pianohacker/quickprom/internal/output"
 )
@@ -234,6 +237,31 @@ var _ = Describe("Value Info", func() {
 			Expect(info.MaxValueFracLength).To(Equal(3))
 		})
 
+		It("supports fraction length for whole numbers", func() {
+			info := output.InstantVectorInfo(model.Vector{
+				{
+					Value: 999999,
+				},
+				{
+					Value: 1234500,
+				},
+			})
+
+			Expect(info.MaxValueExp).To(Equal(6))
+			Expect(info.MinValueExp).To(Equal(5))
+			// 9.99999e+05
+			Expect(info.MaxValueFracLength).To(Equal(5))
+		})
+
+		It("counts the digits of every value with an exponent when one is needed", func() {
+			info := output.FloatsInfo([]float64{1e-6, 12345, 0.5})
+
+			Expect(info.MinValueExp).To(Equal(-6))
+			Expect(info.MaxValueExp).To(Equal(4))
+			// 1.2345e+04
+			Expect(info.MaxValueFracLength).To(Equal(4))
+		})
+
 		It("supports fraction length for tiny values", func() {
 			info := output.InstantVectorInfo(model.Vector{
 				{
@@ -263,6 +291,48 @@ var _ = Describe("Value Info", func() {
 			Expect(info.MinValueExp).To(Equal(10))
 		})
 
+		It("uses the magnitude of negative values", func() {
+			info := output.InstantVectorInfo(model.Vector{
+				{
+					Value: -1.25,
+				},
+				{
+					Value: -0.003,
+				},
+				{
+					Value: 40,
+				},
+			})
+
+			Expect(info.MaxValueExp).To(Equal(1))
+			Expect(info.MinValueExp).To(Equal(-3))
+			Expect(info.MaxValueFracLength).To(Equal(3))
+		})
+
+		It("ignores NaN, infinite values and stale markers", func() {
+			info := output.InstantVectorInfo(model.Vector{
+				{
+					Value: 1.5,
+				},
+				{
+					Value: model.SampleValue(math.NaN()),
+				},
+				{
+					Value: model.SampleValue(math.Inf(1)),
+				},
+				{
+					Value: model.SampleValue(math.Inf(-1)),
+				},
+				{
+					Value: model.SampleValue(math.Float64frombits(value.StaleNaN)),
+				},
+			})
+
+			Expect(info.MaxValueExp).To(Equal(0))
+			Expect(info.MinValueExp).To(Equal(0))
+			Expect(info.MaxValueFracLength).To(Equal(1))
+		})
+
 		It("returns 0 if no nonzero values are seen", func() {
 			info := output.InstantVectorInfo(model.Vector{
 				{
@@ -320,4 +390,47 @@ var _ = Describe("Value Info", func() {
 			Expect(info.MaxValueFracLength).To(Equal(1))
 		})
 	})
+
+	DescribeTable("ClassicHistogram",
+		func(expected bool, metrics ...model.Metric) {
+			var vector model.Vector
+			for _, metric := range metrics {
+				vector = append(vector, &model.Sample{Metric: metric})
+			}
+
+			Expect(output.InstantVectorInfo(vector).ClassicHistogram).To(Equal(expected))
+		},
+
+		Entry("detects buckets",
+			true,
+			model.Metric{"__name__": "a_bucket", "le": "0.1"},
+			model.Metric{"__name__": "a_bucket", "le": "+Inf"},
+		),
+		Entry("detects buckets without names",
+			true,
+			model.Metric{"le": "0.1"},
+			model.Metric{"le": "+Inf"},
+		),
+		Entry("ignores empty vectors", false),
+		Entry("ignores a single bucket",
+			false,
+			model.Metric{"__name__": "a_bucket", "handler": "a", "le": "1"},
+			model.Metric{"__name__": "a_bucket", "handler": "b", "le": "1"},
+		),
+		Entry("ignores names not ending with _bucket",
+			false,
+			model.Metric{"__name__": "a", "le": "0.1"},
+			model.Metric{"__name__": "a", "le": "+Inf"},
+		),
+		Entry("ignores samples without le",
+			false,
+			model.Metric{"le": "0.1"},
+			model.Metric{"handler": "a"},
+		),
+		Entry("ignores non-numeric le",
+			false,
+			model.Metric{"le": "0.1"},
+			model.Metric{"le": "potato"},
+		),
+	)
 })
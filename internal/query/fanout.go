@@ -0,0 +1,180 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// TargetLabel is added to every series when a query is run against more than one target, so
+// results from different targets can be told apart.
+const TargetLabel = "target"
+
+// API is the part of the Prometheus HTTP API needed to run queries, so queries can also be run
+// locally.
+type API interface {
+	Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error)
+	QueryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error)
+}
+
+type Target struct {
+	Name string
+	API  API
+	// Set for Prometheus servers, whose range query results can be streamed rather than decoded whole
+	Streamer *RangeStreamer
+	// Shared by every query sent to the target, so batches of split range queries don't multiply
+	// the number sent at once
+	Limiter *Limiter
+}
+
+type TargetQueryFunc func(target Target) (model.Value, v1.Warnings, error)
+
+// FanOut runs a query against all of the given targets at once, then merges the results. A single
+// target's result is returned as-is. Warnings are returned from every target, prefixed with the
+// target's name if there is more than one.
+func FanOut(targets []Target, queryFunc TargetQueryFunc) (model.Value, v1.Warnings, error) {
+	if len(targets) == 1 {
+		return queryFunc(targets[0])
+	}
+
+	values := make([]model.Value, len(targets))
+	targetWarnings := make([]v1.Warnings, len(targets))
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+
+			values[i], targetWarnings[i], errs[i] = queryFunc(target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var names []string
+	var warnings v1.Warnings
+	for i, target := range targets {
+		for _, warning := range targetWarnings[i] {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", target.Name, warning))
+		}
+
+		if errs[i] != nil {
+			return nil, warnings, fmt.Errorf("%s: %w", target.Name, errs[i])
+		}
+
+		names = append(names, target.Name)
+	}
+
+	value, err := MergeTargetValues(names, values)
+
+	return value, warnings, err
+}
+
+// MergeTargetValues combines the results of the same query from several targets into one value,
+// labeling each series with the name of the target it came from. Scalars are turned into a vector
+// with one sample per target.
+func MergeTargetValues(targetNames []string, values []model.Value) (model.Value, error) {
+	var resultType model.ValueType
+	for i, value := range values {
+		if i == 0 {
+			resultType = value.Type()
+		} else if value.Type() != resultType {
+			return nil, fmt.Errorf(
+				"%s returned a %s, but %s returned a %s",
+				targetNames[0], resultType,
+				targetNames[i], value.Type(),
+			)
+		}
+	}
+
+	switch resultType {
+	case model.ValScalar:
+		result := model.Vector{}
+		for i, value := range values {
+			scalar := value.(*model.Scalar)
+
+			result = append(result, &model.Sample{
+				Metric:    labelWithTarget(nil, targetNames[i]),
+				Value:     scalar.Value,
+				Timestamp: scalar.Timestamp,
+			})
+		}
+
+		return result, nil
+	case model.ValVector:
+		result := model.Vector{}
+		for i, value := range values {
+			for _, sample := range value.(model.Vector) {
+				result = append(result, &model.Sample{
+					Metric:    labelWithTarget(sample.Metric, targetNames[i]),
+					Value:     sample.Value,
+					Histogram: sample.Histogram,
+					Timestamp: sample.Timestamp,
+				})
+			}
+		}
+
+		return result, nil
+	case model.ValMatrix:
+		result := model.Matrix{}
+		for i, value := range values {
+			for _, series := range value.(model.Matrix) {
+				result = append(result, &model.SampleStream{
+					Metric:     labelWithTarget(series.Metric, targetNames[i]),
+					Values:     series.Values,
+					Histograms: series.Histograms,
+				})
+			}
+		}
+
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("cannot merge results of type %s", resultType)
+}
+
+// labelWithTarget adds the target label to a copy of `metric`. Like Prometheus does with the labels
+// it adds when scraping, a `target` label the series already has is kept as `exported_target`, so
+// series that only differ by it don't collide.
+func labelWithTarget(metric model.Metric, targetName string) model.Metric {
+	result := metric.Clone()
+
+	if existing, ok := result[TargetLabel]; ok {
+		exportedLabel := model.LabelName("exported_" + TargetLabel)
+		for result[exportedLabel] != "" {
+			exportedLabel = "exported_" + exportedLabel
+		}
+
+		result[exportedLabel] = existing
+	}
+
+	result[TargetLabel] = model.LabelValue(targetName)
+
+	return result
+}
+
+// AsVector returns the result of an instant query as a vector, turning scalars into a single
+// unlabeled sample.
+func AsVector(value model.Value) (model.Vector, error) {
+	switch value.Type() {
+	case model.ValScalar:
+		scalar := value.(*model.Scalar)
+
+		return model.Vector{
+			{
+				Metric:    model.Metric{},
+				Value:     scalar.Value,
+				Timestamp: scalar.Timestamp,
+			},
+		}, nil
+	case model.ValVector:
+		return value.(model.Vector), nil
+	}
+
+	return nil, fmt.Errorf("cannot use a %s as an instant vector", value.Type())
+}
@@ -0,0 +1,194 @@
+package query_test
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+var _ = Describe("Fan-out", func() {
+	Describe("FanOut()", func() {
+		targets := []query.Target{{Name: "target-a"}, {Name: "target-b"}}
+
+		It("prefixes each warning with its target", func() {
+			_, warnings, err := query.FanOut(targets, func(target query.Target) (model.Value, v1.Warnings, error) {
+				return model.Vector{}, v1.Warnings{"partial response"}, nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(Equal(v1.Warnings{
+				"target-a: partial response",
+				"target-b: partial response",
+			}))
+		})
+
+		It("returns a single target's result and warnings as-is", func() {
+			value, warnings, err := query.FanOut(targets[:1], func(target query.Target) (model.Value, v1.Warnings, error) {
+				return &model.Scalar{Value: 1}, v1.Warnings{"partial response"}, nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(&model.Scalar{Value: 1}))
+			Expect(warnings).To(Equal(v1.Warnings{"partial response"}))
+		})
+
+		It("returns the error of a failed target", func() {
+			_, _, err := query.FanOut(targets, func(target query.Target) (model.Value, v1.Warnings, error) {
+				if target.Name == "target-b" {
+					return nil, nil, errors.New("timed out")
+				}
+
+				return model.Vector{}, nil, nil
+			})
+
+			Expect(err).To(MatchError("target-b: timed out"))
+		})
+	})
+
+	Describe("MergeTargetValues()", func() {
+		It("labels instant vector samples with their target", func() {
+			merged, err := query.MergeTargetValues(
+				[]string{"target-a", "target-b"},
+				[]model.Value{
+					model.Vector{
+						{Metric: model.Metric{"job": "a"}, Value: 1, Timestamp: 4},
+					},
+					model.Vector{
+						{Metric: model.Metric{"job": "a"}, Value: 2, Timestamp: 4},
+						{Metric: model.Metric{"job": "b"}, Value: 3, Timestamp: 4},
+					},
+				},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(merged).To(Equal(model.Vector{
+				{Metric: model.Metric{"job": "a", "target": "target-a"}, Value: 1, Timestamp: 4},
+				{Metric: model.Metric{"job": "a", "target": "target-b"}, Value: 2, Timestamp: 4},
+				{Metric: model.Metric{"job": "b", "target": "target-b"}, Value: 3, Timestamp: 4},
+			}))
+		})
+
+		It("labels range vector series with their target", func() {
+			values := []model.SamplePair{{Timestamp: 1, Value: 1}}
+
+			merged, err := query.MergeTargetValues(
+				[]string{"target-a", "target-b"},
+				[]model.Value{
+					model.Matrix{
+						{Metric: model.Metric{"job": "a"}, Values: values},
+					},
+					model.Matrix{},
+				},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(merged).To(Equal(model.Matrix{
+				{Metric: model.Metric{"job": "a", "target": "target-a"}, Values: values},
+			}))
+		})
+
+		It("keeps native histograms", func() {
+			histogram := &model.SampleHistogram{Count: 1, Sum: 2}
+			histograms := []model.SampleHistogramPair{{Timestamp: 1, Histogram: histogram}}
+
+			merged, err := query.MergeTargetValues(
+				[]string{"target-a", "target-b"},
+				[]model.Value{
+					model.Vector{{Metric: model.Metric{}, Histogram: histogram}},
+					model.Vector{},
+				},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(merged.(model.Vector)[0].Histogram).To(Equal(histogram))
+
+			merged, err = query.MergeTargetValues(
+				[]string{"target-a", "target-b"},
+				[]model.Value{
+					model.Matrix{{Metric: model.Metric{}, Histograms: histograms}},
+					model.Matrix{},
+				},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(merged.(model.Matrix)[0].Histograms).To(Equal(histograms))
+		})
+
+		It("turns scalars into an instant vector", func() {
+			merged, err := query.MergeTargetValues(
+				[]string{"target-a", "target-b"},
+				[]model.Value{
+					&model.Scalar{Value: 1, Timestamp: 4},
+					&model.Scalar{Value: 2, Timestamp: 4},
+				},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(merged).To(Equal(model.Vector{
+				{Metric: model.Metric{"target": "target-a"}, Value: 1, Timestamp: 4},
+				{Metric: model.Metric{"target": "target-b"}, Value: 2, Timestamp: 4},
+			}))
+		})
+
+		It("keeps target labels the series already have", func() {
+			merged, err := query.MergeTargetValues(
+				[]string{"target-a", "target-b"},
+				[]model.Value{
+					model.Vector{
+						{Metric: model.Metric{"target": "https://a.example.com"}, Value: 1},
+						{Metric: model.Metric{"target": "https://b.example.com"}, Value: 2},
+					},
+					model.Vector{
+						{Metric: model.Metric{"target": "https://a.example.com", "exported_target": "a"}, Value: 3},
+					},
+				},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(merged).To(Equal(model.Vector{
+				{Metric: model.Metric{"exported_target": "https://a.example.com", "target": "target-a"}, Value: 1},
+				{Metric: model.Metric{"exported_target": "https://b.example.com", "target": "target-a"}, Value: 2},
+				{
+					Metric: model.Metric{
+						"exported_exported_target": "https://a.example.com",
+						"exported_target":          "a",
+						"target":                   "target-b",
+					},
+					Value: 3,
+				},
+			}))
+		})
+
+		It("doesn't modify the original metrics", func() {
+			original := model.Metric{"job": "a"}
+
+			_, err := query.MergeTargetValues(
+				[]string{"target-a"},
+				[]model.Value{
+					model.Vector{{Metric: original}},
+				},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(original).To(Equal(model.Metric{"job": "a"}))
+		})
+
+		It("returns an error when targets return different types", func() {
+			_, err := query.MergeTargetValues(
+				[]string{"target-a", "target-b"},
+				[]model.Value{
+					model.Vector{},
+					model.Matrix{},
+				},
+			)
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,44 @@
+package query
+
+import (
+	"context"
+)
+
+// Limiter bounds how many queries are sent to a target at once, however many pools of workers are
+// sending them. A nil Limiter doesn't limit anything.
+type Limiter struct {
+	slots chan struct{}
+}
+
+func NewLimiter(concurrency int) *Limiter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Limiter{
+		slots: make(chan struct{}, concurrency),
+	}
+}
+
+// Acquire waits until a query can be sent, or returns an error if `ctx` is done first. Release has
+// to be called once the query is done.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+
+	<-l.slots
+}
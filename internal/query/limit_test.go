@@ -0,0 +1,62 @@
+package query_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+var _ = Describe("Limiter", func() {
+	It("only lets the given number of queries run at once", func() {
+		limiter := query.NewLimiter(2)
+
+		var running, maxRunning int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				Expect(limiter.Acquire(context.Background())).To(Succeed())
+				defer limiter.Release()
+
+				now := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+						break
+					}
+				}
+
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			}()
+		}
+		wg.Wait()
+
+		Expect(maxRunning).To(Equal(int32(2)))
+	})
+
+	It("stops waiting when the context is done", func() {
+		limiter := query.NewLimiter(1)
+		Expect(limiter.Acquire(context.Background())).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(limiter.Acquire(ctx)).To(MatchError(context.Canceled))
+	})
+
+	It("doesn't limit anything when nil", func() {
+		var limiter *query.Limiter
+
+		Expect(limiter.Acquire(context.Background())).To(Succeed())
+		limiter.Release()
+	})
+})
@@ -0,0 +1,169 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// MaxPointsPerSeries is the most points Prometheus will return for each series of a range query.
+const MaxPointsPerSeries = 11000
+
+type RangeQueryFunc func(ctx context.Context, r v1.Range) (model.Value, v1.Warnings, error)
+
+// SplitRange divides a range into consecutive chunks covering at most `maxDuration` each, and never
+// more than MaxPointsPerSeries steps. Chunks start on the same steps the whole range would be
+// evaluated at and don't overlap, so together they return exactly the points of the whole range.
+func SplitRange(r v1.Range, maxDuration time.Duration) []v1.Range {
+	if r.Step <= 0 {
+		return []v1.Range{r}
+	}
+
+	stepsPerChunk := int64(MaxPointsPerSeries)
+	if maxDuration > 0 {
+		// Each chunk includes its first and last step
+		stepsPerChunk = min(stepsPerChunk, int64(maxDuration/r.Step)+1)
+	}
+	chunkSpan := time.Duration(stepsPerChunk-1) * r.Step
+
+	var chunks []v1.Range
+	for start := r.Start; !start.After(r.End); start = start.Add(chunkSpan + r.Step) {
+		end := start.Add(chunkSpan)
+		if end.After(r.End) {
+			end = r.End
+		}
+
+		chunks = append(chunks, v1.Range{Start: start, End: end, Step: r.Step})
+	}
+
+	return chunks
+}
+
+// QueryRangeInChunks runs a range query in the chunks given by SplitRange, at most `concurrency`
+// at once, then stitches the resulting series back together. The first error cancels any chunks
+// still running. Statistics collected by `ctx` are totaled over all chunks.
+func QueryRangeInChunks(ctx context.Context, r v1.Range, maxDuration time.Duration, concurrency int, queryFunc RangeQueryFunc) (model.Value, v1.Warnings, error) {
+	chunks := SplitRange(r, maxDuration)
+	if len(chunks) == 1 {
+		return queryFunc(ctx, chunks[0])
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	values := make([]model.Value, len(chunks))
+	chunkWarnings := make([]v1.Warnings, len(chunks))
+	chunkStats := make([]*Stats, len(chunks))
+	indices := make(chan int)
+
+	var firstErr error
+	var errOnce sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				chunkCtx := ctx
+				if StatsFrom(ctx) != nil {
+					chunkCtx, chunkStats[i] = WithStats(ctx)
+				}
+
+				var err error
+				values[i], chunkWarnings[i], err = queryFunc(chunkCtx, chunks[i])
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf(
+							"chunk from %s to %s: %w",
+							chunks[i].Start.Format(time.RFC3339), chunks[i].End.Format(time.RFC3339),
+							err,
+						)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	// Chunks left after an error fail quickly, as their context is canceled
+	for i := range chunks {
+		indices <- i
+	}
+	close(indices)
+
+	wg.Wait()
+
+	// The same warning usually comes back for every chunk
+	var warnings v1.Warnings
+	seenWarnings := map[string]bool{}
+	for _, chunk := range chunkWarnings {
+		for _, warning := range chunk {
+			if !seenWarnings[warning] {
+				seenWarnings[warning] = true
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return nil, warnings, firstErr
+	}
+
+	if stats := StatsFrom(ctx); stats != nil {
+		for _, chunk := range chunkStats {
+			stats.add(chunk)
+		}
+	}
+
+	matrices := make([]model.Matrix, len(values))
+	for i, value := range values {
+		matrix, ok := value.(model.Matrix)
+		if !ok {
+			return nil, warnings, fmt.Errorf("cannot stitch together a %s", value.Type())
+		}
+
+		matrices[i] = matrix
+	}
+
+	return StitchMatrices(matrices), warnings, nil
+}
+
+// StitchMatrices joins the results of consecutive chunks of a range query, matching series by
+// their labels. Series are sorted by their labels, as Prometheus does.
+func StitchMatrices(matrices []model.Matrix) model.Matrix {
+	byFingerprint := map[model.Fingerprint]*model.SampleStream{}
+	result := model.Matrix{}
+
+	for _, matrix := range matrices {
+		for _, series := range matrix {
+			fingerprint := series.Metric.Fingerprint()
+
+			stitched, ok := byFingerprint[fingerprint]
+			if !ok {
+				stitched = &model.SampleStream{Metric: series.Metric}
+				byFingerprint[fingerprint] = stitched
+				result = append(result, stitched)
+			}
+
+			stitched.Values = append(stitched.Values, series.Values...)
+			stitched.Histograms = append(stitched.Histograms, series.Histograms...)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Metric.Before(result[j].Metric)
+	})
+
+	return result
+}
@@ -0,0 +1,152 @@
+package query_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+var _ = Describe("Splitting", func() {
+	start := time.Unix(0, 0).UTC()
+
+	Describe("SplitRange()", func() {
+		It("keeps a short range whole", func() {
+			r := v1.Range{Start: start, End: start.Add(time.Hour), Step: time.Minute}
+
+			Expect(query.SplitRange(r, 0)).To(Equal([]v1.Range{r}))
+		})
+
+		It("splits ranges with too many points into adjacent chunks on the same steps", func() {
+			r := v1.Range{Start: start, End: start.Add(90 * 24 * time.Hour), Step: 5 * time.Minute}
+
+			chunks := query.SplitRange(r, 0)
+
+			Expect(chunks).To(HaveLen(3))
+			Expect(chunks[0]).To(Equal(v1.Range{Start: start, End: start.Add(10999 * 5 * time.Minute), Step: 5 * time.Minute}))
+			Expect(chunks[1].Start).To(Equal(chunks[0].End.Add(5 * time.Minute)))
+			Expect(chunks[2].Start).To(Equal(chunks[1].End.Add(5 * time.Minute)))
+			Expect(chunks[2].End).To(Equal(r.End))
+		})
+
+		It("splits ranges into chunks of at most the given duration", func() {
+			r := v1.Range{Start: start, End: start.Add(5 * time.Hour), Step: time.Hour}
+
+			Expect(query.SplitRange(r, 2*time.Hour)).To(Equal([]v1.Range{
+				{Start: start, End: start.Add(2 * time.Hour), Step: time.Hour},
+				{Start: start.Add(3 * time.Hour), End: start.Add(5 * time.Hour), Step: time.Hour},
+			}))
+		})
+	})
+
+	Describe("QueryRangeInChunks()", func() {
+		r := v1.Range{Start: start, End: start.Add(3 * time.Hour), Step: time.Hour}
+
+		chunkResult := func(chunk v1.Range) model.Matrix {
+			var values []model.SamplePair
+			for t := chunk.Start; !t.After(chunk.End); t = t.Add(chunk.Step) {
+				values = append(values, model.SamplePair{Timestamp: model.TimeFromUnixNano(t.UnixNano()), Value: 1})
+			}
+
+			return model.Matrix{{Metric: model.Metric{"job": "a"}, Values: values}}
+		}
+
+		It("stitches the chunks back together", func() {
+			value, _, err := query.QueryRangeInChunks(context.Background(), r, time.Hour, 2, func(ctx context.Context, chunk v1.Range) (model.Value, v1.Warnings, error) {
+				return chunkResult(chunk), nil, nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(chunkResult(r)))
+		})
+
+		It("runs at most the given number of chunks at once", func() {
+			var mu sync.Mutex
+			running, maxRunning := 0, 0
+
+			_, _, err := query.QueryRangeInChunks(context.Background(), r, time.Nanosecond, 2, func(ctx context.Context, chunk v1.Range) (model.Value, v1.Warnings, error) {
+				mu.Lock()
+				running++
+				maxRunning = max(maxRunning, running)
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+
+				return chunkResult(chunk), nil, nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(maxRunning).To(Equal(2))
+		})
+
+		It("returns each warning once", func() {
+			_, warnings, err := query.QueryRangeInChunks(context.Background(), r, time.Hour, 2, func(ctx context.Context, chunk v1.Range) (model.Value, v1.Warnings, error) {
+				return chunkResult(chunk), v1.Warnings{"partial response"}, nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(Equal(v1.Warnings{"partial response"}))
+		})
+
+		It("returns the error of a failed chunk", func() {
+			_, _, err := query.QueryRangeInChunks(context.Background(), r, time.Hour, 2, func(ctx context.Context, chunk v1.Range) (model.Value, v1.Warnings, error) {
+				if chunk.Start.Equal(start) {
+					return nil, nil, errors.New("timed out")
+				}
+
+				return chunkResult(chunk), nil, nil
+			})
+
+			Expect(err).To(MatchError("chunk from 1970-01-01T00:00:00Z to 1970-01-01T01:00:00Z: timed out"))
+		})
+
+		It("totals the stats of every chunk", func() {
+			ctx, stats := query.WithStats(context.Background())
+
+			_, _, err := query.QueryRangeInChunks(ctx, r, time.Hour, 2, func(ctx context.Context, chunk v1.Range) (model.Value, v1.Warnings, error) {
+				chunkStats := query.StatsFrom(ctx)
+				chunkStats.Found = true
+				chunkStats.Samples.TotalQueryableSamples = 100
+				chunkStats.Samples.PeakSamples = int(chunk.Start.Unix() / 3600)
+
+				return chunkResult(chunk), nil, nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stats.Found).To(BeTrue())
+			Expect(stats.Samples.TotalQueryableSamples).To(Equal(int64(200)))
+			Expect(stats.Samples.PeakSamples).To(Equal(2))
+		})
+	})
+
+	Describe("StitchMatrices()", func() {
+		It("joins series by their labels, sorted", func() {
+			stitched := query.StitchMatrices([]model.Matrix{
+				{
+					{Metric: model.Metric{"job": "b"}, Values: []model.SamplePair{{Timestamp: 1, Value: 1}}},
+				},
+				{
+					{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 2, Value: 2}}},
+					{Metric: model.Metric{"job": "b"}, Values: []model.SamplePair{{Timestamp: 2, Value: 3}}},
+				},
+			})
+
+			Expect(stitched).To(Equal(model.Matrix{
+				{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 2, Value: 2}}},
+				{Metric: model.Metric{"job": "b"}, Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 3}}},
+			}))
+		})
+	})
+})
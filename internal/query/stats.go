@@ -0,0 +1,129 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Stats are the execution statistics of a query, as returned by the Prometheus API with `stats`
+// set. Timings are in seconds.
+type Stats struct {
+	Timings struct {
+		EvalTotalTime        float64 `json:"evalTotalTime"`
+		ResultSortTime       float64 `json:"resultSortTime"`
+		QueryPreparationTime float64 `json:"queryPreparationTime"`
+		InnerEvalTime        float64 `json:"innerEvalTime"`
+		ExecQueueTime        float64 `json:"execQueueTime"`
+		ExecTotalTime        float64 `json:"execTotalTime"`
+	} `json:"timings"`
+	Samples struct {
+		TotalQueryableSamples int64 `json:"totalQueryableSamples"`
+		PeakSamples           int   `json:"peakSamples"`
+	} `json:"samples"`
+
+	// Found is false if the server didn't return any statistics
+	Found bool `json:"-"`
+}
+
+func (s *Stats) String() string {
+	return fmt.Sprintf(
+		"%d samples queried, %d at peak; %s total (%s queued, %s preparing, %s evaluating, %s sorting)",
+		s.Samples.TotalQueryableSamples,
+		s.Samples.PeakSamples,
+		secondsDuration(s.Timings.ExecTotalTime),
+		secondsDuration(s.Timings.ExecQueueTime),
+		secondsDuration(s.Timings.QueryPreparationTime),
+		secondsDuration(s.Timings.InnerEvalTime),
+		secondsDuration(s.Timings.ResultSortTime),
+	)
+}
+
+// add totals the statistics of a query run in several parts. Their peaks didn't necessarily
+// happen at once, so the highest is kept.
+func (s *Stats) add(other *Stats) {
+	if !other.Found {
+		return
+	}
+
+	s.Found = true
+	s.Timings.EvalTotalTime += other.Timings.EvalTotalTime
+	s.Timings.ResultSortTime += other.Timings.ResultSortTime
+	s.Timings.QueryPreparationTime += other.Timings.QueryPreparationTime
+	s.Timings.InnerEvalTime += other.Timings.InnerEvalTime
+	s.Timings.ExecQueueTime += other.Timings.ExecQueueTime
+	s.Timings.ExecTotalTime += other.Timings.ExecTotalTime
+	s.Samples.TotalQueryableSamples += other.Samples.TotalQueryableSamples
+	s.Samples.PeakSamples = max(s.Samples.PeakSamples, other.Samples.PeakSamples)
+}
+
+// isQueryPath returns whether `path` is that of an instant or range query, under whatever prefix the
+// target is served at.
+func isQueryPath(path string) bool {
+	return strings.HasSuffix(path, "/api/v1/query") || strings.HasSuffix(path, "/api/v1/query_range")
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Microsecond)
+}
+
+type statsKey struct{}
+
+// WithStats returns a context that collects the statistics of the query made with it.
+func WithStats(ctx context.Context) (context.Context, *Stats) {
+	stats := &Stats{}
+
+	return context.WithValue(ctx, statsKey{}, stats), stats
+}
+
+// StatsFrom returns the statistics being collected by `ctx`, if any.
+func StatsFrom(ctx context.Context) *Stats {
+	stats, _ := ctx.Value(statsKey{}).(*Stats)
+
+	return stats
+}
+
+type statsRoundTripper struct {
+	roundTripper http.RoundTripper
+}
+
+// StatsRoundTripper reads the statistics out of query responses for requests made with a context
+// from WithStats, as the Prometheus client drops them.
+func StatsRoundTripper(roundTripper http.RoundTripper) http.RoundTripper {
+	return &statsRoundTripper{roundTripper: roundTripper}
+}
+
+func (s *statsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := s.roundTripper.RoundTrip(req)
+
+	// Only responses that have to be read for stats are buffered
+	stats := StatsFrom(req.Context())
+	if err != nil || stats == nil || !isQueryPath(req.URL.Path) {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var document struct {
+		Data struct {
+			Stats json.RawMessage `json:"stats"`
+		} `json:"data"`
+	}
+
+	// Errors are left for the client to report
+	if json.Unmarshal(body, &document) == nil && len(document.Data.Stats) != 0 && string(document.Data.Stats) != "null" {
+		stats.Found = json.Unmarshal(document.Data.Stats, stats) == nil
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,118 @@
+package query_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var _ = Describe("Stats", func() {
+	Describe("StatsRoundTripper()", func() {
+		var server *httptest.Server
+		var client *http.Client
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("stats") == "" {
+					fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": []}}`)
+					return
+				}
+
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [], "stats": {
+					"timings": {"execTotalTime": 0.25, "innerEvalTime": 0.2},
+					"samples": {"totalQueryableSamples": 1200, "peakSamples": 30}
+				}}}`)
+			}))
+
+			client = &http.Client{Transport: query.StatsRoundTripper(http.DefaultTransport)}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		get := func(ctx context.Context, url string) string {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := client.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			return string(body)
+		}
+
+		It("collects stats from query responses", func() {
+			ctx, stats := query.WithStats(context.Background())
+
+			body := get(ctx, server.URL+"/api/v1/query?stats=all")
+
+			Expect(body).To(ContainSubstring(`"resultType": "vector"`))
+			Expect(stats.Found).To(BeTrue())
+			Expect(stats.Samples.TotalQueryableSamples).To(Equal(int64(1200)))
+			Expect(stats.Samples.PeakSamples).To(Equal(30))
+			Expect(stats.String()).To(Equal(
+				"1200 samples queried, 30 at peak; 250ms total (0s queued, 0s preparing, 200ms evaluating, 0s sorting)",
+			))
+		})
+
+		It("notes when the server returns no stats", func() {
+			ctx, stats := query.WithStats(context.Background())
+
+			get(ctx, server.URL+"/api/v1/query_range")
+
+			Expect(stats.Found).To(BeFalse())
+		})
+
+		It("leaves requests without a stats context alone", func() {
+			body := get(context.Background(), server.URL+"/api/v1/query?stats=all")
+
+			Expect(body).To(ContainSubstring(`"stats"`))
+		})
+
+		It("passes on the bodies of other responses without reading them", func() {
+			statsCtx, stats := query.WithStats(context.Background())
+
+			for _, request := range []struct {
+				ctx  context.Context
+				path string
+			}{
+				{context.Background(), "/api/v1/query"},
+				{statsCtx, "/api/v1/labels"},
+				{statsCtx, "/api/v1/query_exemplars"},
+			} {
+				body := ioutil.NopCloser(strings.NewReader(`{"status": "success", "data": {"stats": {}}}`))
+				inner := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusOK, Body: body}, nil
+				})
+
+				req, err := http.NewRequestWithContext(request.ctx, http.MethodGet, "http://prometheus"+request.path, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				resp, err := query.StatsRoundTripper(inner).RoundTrip(req)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.Body).To(BeIdenticalTo(body))
+			}
+
+			Expect(stats.Found).To(BeFalse())
+		})
+	})
+})
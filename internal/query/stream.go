@@ -0,0 +1,363 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// RangeStreamer runs range queries against a Prometheus server without decoding their whole
+// results. Each response is saved to a temporary file, then read back one series at a time, so
+// results too large to decode at once can still be shown.
+type RangeStreamer struct {
+	client   *http.Client
+	endpoint *url.URL
+}
+
+// NewRangeStreamer returns a RangeStreamer for the server at `address`. Statistics are read by the
+// streamer itself, so `roundTripper` shouldn't be a StatsRoundTripper.
+func NewRangeStreamer(address string, roundTripper http.RoundTripper) (*RangeStreamer, error) {
+	endpoint, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Path = strings.TrimRight(endpoint.Path, "/")
+
+	return &RangeStreamer{
+		client:   &http.Client{Transport: roundTripper},
+		endpoint: endpoint,
+	}, nil
+}
+
+// QueryRange runs a range query the way the Prometheus client does, POSTing it and falling back to
+// GET if the server refuses that. Errors are returned in the same form, and statistics are collected
+// if `ctx` is from WithStats. The result has to be closed once it's no longer needed.
+func (s *RangeStreamer) QueryRange(ctx context.Context, query string, r v1.Range) (*SpooledMatrix, v1.Warnings, error) {
+	args := url.Values{}
+	args.Set("query", query)
+	args.Set("start", formatTime(r.Start))
+	args.Set("end", formatTime(r.End))
+	args.Set("step", strconv.FormatFloat(r.Step.Seconds(), 'f', -1, 64))
+
+	stats := StatsFrom(ctx)
+	if stats != nil {
+		args.Set("stats", string(v1.AllStatsValue))
+	}
+
+	u := *s.endpoint
+	u.Path = path.Join(u.Path, "/api/v1/query_range")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(args.Encode()))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Queries don't change anything, so they can be retried like GETs
+	req.Header["Idempotency-Key"] = nil
+
+	resp, err := s.client.Do(req)
+	if err == nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+
+		u.RawQuery = args.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resp, err = s.client.Do(req)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 && !isAPIErrorCode(resp.StatusCode) {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return nil, nil, &v1.Error{
+			Type:   errorTypeFor(resp.StatusCode),
+			Msg:    errorMsgFor(resp.StatusCode),
+			Detail: string(body),
+		}
+	}
+
+	file, err := ioutil.TempFile("", "quickprom-result-")
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &SpooledMatrix{file: file}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		m.Close()
+
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+
+	warnings, err := m.readResponse(resp.StatusCode, stats)
+	if err != nil {
+		m.Close()
+		return nil, warnings, err
+	}
+
+	return m, warnings, nil
+}
+
+// formatTime formats a time the way the Prometheus client does.
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.Unix())+float64(t.Nanosecond())/1e9, 'f', -1, 64)
+}
+
+// isAPIErrorCode returns whether Prometheus sends `code` with an error in the response body.
+func isAPIErrorCode(code int) bool {
+	return code == http.StatusBadRequest || code == http.StatusUnprocessableEntity
+}
+
+func errorTypeFor(code int) v1.ErrorType {
+	switch code / 100 {
+	case 4:
+		return v1.ErrClient
+	case 5:
+		return v1.ErrServer
+	}
+
+	return v1.ErrBadResponse
+}
+
+func errorMsgFor(code int) string {
+	switch code / 100 {
+	case 4:
+		return fmt.Sprintf("client error: %d", code)
+	case 5:
+		return fmt.Sprintf("server error: %d", code)
+	}
+
+	return fmt.Sprintf("bad response code %d", code)
+}
+
+// SpooledMatrix is the matrix result of a range query, saved in a temporary file rather than
+// decoded.
+type SpooledMatrix struct {
+	file        *os.File
+	seriesCount int
+	err         error
+}
+
+// readResponse checks the whole response and reads everything in it but the series themselves.
+func (m *SpooledMatrix) readResponse(code int, stats *Stats) (v1.Warnings, error) {
+	var status, errorType, errorMsg, resultType string
+	var warnings v1.Warnings
+
+	iter, err := m.iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+		switch key {
+		case "status":
+			status = iter.ReadString()
+		case "errorType":
+			errorType = iter.ReadString()
+		case "error":
+			errorMsg = iter.ReadString()
+		case "warnings":
+			iter.ReadVal(&warnings)
+		case "data":
+			if iter.WhatIsNext() == jsoniter.NilValue {
+				iter.Skip()
+				return true
+			}
+
+			iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+				switch {
+				case key == "resultType":
+					resultType = iter.ReadString()
+				case key == "result" && iter.WhatIsNext() == jsoniter.ArrayValue:
+					iter.ReadArrayCB(func(iter *jsoniter.Iterator) bool {
+						m.seriesCount++
+						iter.Skip()
+						return true
+					})
+				case key == "stats" && stats != nil && iter.WhatIsNext() == jsoniter.ObjectValue:
+					iter.ReadVal(stats)
+					stats.Found = iter.Error == nil
+				default:
+					iter.Skip()
+				}
+
+				return iter.Error == nil
+			})
+		default:
+			iter.Skip()
+		}
+
+		return iter.Error == nil
+	})
+
+	if iter.Error != nil {
+		return nil, &v1.Error{Type: v1.ErrBadResponse, Msg: iter.Error.Error()}
+	}
+
+	if status == "error" {
+		return warnings, &v1.Error{Type: v1.ErrorType(errorType), Msg: errorMsg}
+	}
+
+	if isAPIErrorCode(code) && status == "success" {
+		return warnings, &v1.Error{Type: v1.ErrBadResponse, Msg: "inconsistent body for response code"}
+	}
+
+	if resultType != model.ValMatrix.String() {
+		return warnings, &v1.Error{Type: v1.ErrBadResponse, Msg: fmt.Sprintf("expected a matrix result, got %q", resultType)}
+	}
+
+	return warnings, nil
+}
+
+// iterator reads the response from the start of the file.
+func (m *SpooledMatrix) iterator() (*jsoniter.Iterator, error) {
+	if _, err := m.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return jsoniter.Parse(jsoniter.ConfigCompatibleWithStandardLibrary, m.file, 64*1024), nil
+}
+
+// SeriesCount returns the number of series in the result.
+func (m *SpooledMatrix) SeriesCount() int {
+	return m.seriesCount
+}
+
+// EachSeries decodes each series in turn, so only one is held in memory at a time. It can be called
+// more than once. Any error reading the file is returned by Err.
+func (m *SpooledMatrix) EachSeries(seriesFunc func(series *model.SampleStream)) {
+	iter, err := m.iterator()
+	if err != nil {
+		m.err = err
+		return
+	}
+
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+		if key != "data" {
+			iter.Skip()
+			return true
+		}
+
+		iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+			if key != "result" {
+				iter.Skip()
+				return true
+			}
+
+			iter.ReadArrayCB(func(iter *jsoniter.Iterator) bool {
+				series := readSampleStream(iter)
+				if iter.Error == nil {
+					seriesFunc(series)
+				}
+
+				return iter.Error == nil
+			})
+
+			return iter.Error == nil
+		})
+
+		return iter.Error == nil
+	})
+
+	if iter.Error != nil && m.err == nil {
+		m.err = fmt.Errorf("failed to read result: %w", iter.Error)
+	}
+}
+
+// Err returns the first error from EachSeries, if any.
+func (m *SpooledMatrix) Err() error {
+	return m.err
+}
+
+// Close removes the temporary file.
+func (m *SpooledMatrix) Close() error {
+	m.file.Close()
+
+	return os.Remove(m.file.Name())
+}
+
+// readSampleStream reads a series straight from `iter`. The decoder the Prometheus client registers
+// with jsoniter keeps a copy of each series' JSON to decode it again, which costs more than the
+// series itself, so it's only used for the rarer native histograms.
+func readSampleStream(iter *jsoniter.Iterator) *model.SampleStream {
+	series := &model.SampleStream{}
+
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+		switch key {
+		case "metric":
+			series.Metric = model.Metric{}
+			iter.ReadMapCB(func(iter *jsoniter.Iterator, name string) bool {
+				series.Metric[model.LabelName(name)] = model.LabelValue(iter.ReadString())
+				return true
+			})
+		case "values":
+			iter.ReadArrayCB(func(iter *jsoniter.Iterator) bool {
+				series.Values = append(series.Values, readSamplePair(iter))
+				return iter.Error == nil
+			})
+		case "histograms":
+			iter.ReadVal(&series.Histograms)
+		default:
+			iter.Skip()
+		}
+
+		return iter.Error == nil
+	})
+
+	return series
+}
+
+// readSamplePair reads a `[timestamp, "value"]` pair.
+func readSamplePair(iter *jsoniter.Iterator) model.SamplePair {
+	var pair model.SamplePair
+
+	if !iter.ReadArray() {
+		iter.ReportError("readSamplePair", "expected a timestamp")
+		return pair
+	}
+
+	// Timestamps are read the way the Prometheus client reads them, so fractional seconds come out
+	// the same
+	if err := pair.Timestamp.UnmarshalJSON([]byte(iter.ReadNumber())); err != nil {
+		iter.ReportError("readSamplePair", err.Error())
+		return pair
+	}
+
+	if !iter.ReadArray() {
+		iter.ReportError("readSamplePair", "expected a value")
+		return pair
+	}
+
+	value, err := strconv.ParseFloat(iter.ReadString(), 64)
+	if err != nil {
+		iter.ReportError("readSamplePair", err.Error())
+		return pair
+	}
+	pair.Value = model.SampleValue(value)
+
+	if iter.ReadArray() {
+		iter.ReportError("readSamplePair", "expected the end of the sample")
+	}
+
+	return pair
+}
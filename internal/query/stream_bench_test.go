@@ -0,0 +1,132 @@
+package query_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+// largeMatrixResponse returns a range query response with 1,000 series of 1,000 samples each.
+func largeMatrixResponse() []byte {
+	var b bytes.Buffer
+
+	b.WriteString(`{"status": "success", "data": {"resultType": "matrix", "result": [`)
+	for i := 0; i < 1000; i++ {
+		if i != 0 {
+			b.WriteString(",")
+		}
+
+		fmt.Fprintf(&b, `{"metric": {"__name__": "http_requests_total", "instance": "host-%d:9100", "job": "node"}, "values": [`, i)
+		for j := 0; j < 1000; j++ {
+			if j != 0 {
+				b.WriteString(",")
+			}
+
+			fmt.Fprintf(&b, `[%d, "%d.25"]`, 1700000000+j*15, i*j)
+		}
+		b.WriteString("]}")
+	}
+	b.WriteString("]}}")
+
+	return b.Bytes()
+}
+
+// benchmarkQueryRange reports the memory allocated while querying a large matrix and reading every
+// sample, and how much of it stays in use until the result is done with. The client holds the whole
+// result, while streaming only holds one series at a time.
+func benchmarkQueryRange(b *testing.B, streaming bool) {
+	body := largeMatrixResponse()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(api.Config{Address: server.URL})
+	if err != nil {
+		b.Fatal(err)
+	}
+	queryAPI := v1.NewAPI(client)
+
+	streamer, err := query.NewRangeStreamer(server.URL, http.DefaultTransport)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	r := v1.Range{Start: time.Unix(1700000000, 0), End: time.Unix(1700015000, 0), Step: 15 * time.Second}
+
+	var before, after runtime.MemStats
+	var retained uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		var seriesCount, sampleCount int
+		countSeries := func(series *model.SampleStream) {
+			seriesCount++
+			sampleCount += len(series.Values)
+		}
+
+		if streaming {
+			matrix, _, err := streamer.QueryRange(context.Background(), "http_requests_total", r)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			matrix.EachSeries(countSeries)
+
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			if err := matrix.Err(); err != nil {
+				b.Fatal(err)
+			}
+			matrix.Close()
+		} else {
+			value, _, err := queryAPI.QueryRange(context.Background(), "http_requests_total", r)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for _, series := range value.(model.Matrix) {
+				countSeries(series)
+			}
+
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			runtime.KeepAlive(value)
+		}
+
+		if after.HeapAlloc > before.HeapAlloc {
+			retained += after.HeapAlloc - before.HeapAlloc
+		}
+
+		if seriesCount != 1000 || sampleCount != 1000*1000 {
+			b.Fatalf("got %d series of %d samples", seriesCount, sampleCount)
+		}
+	}
+
+	b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+}
+
+func BenchmarkQueryRangeClientDecoding(b *testing.B) {
+	benchmarkQueryRange(b, false)
+}
+
+func BenchmarkQueryRangeStreaming(b *testing.B) {
+	benchmarkQueryRange(b, true)
+}
@@ -0,0 +1,187 @@
+package query_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/query"
+)
+
+func readSeries(m *query.SpooledMatrix) (matrix model.Matrix) {
+	m.EachSeries(func(series *model.SampleStream) {
+		matrix = append(matrix, series)
+	})
+
+	return
+}
+
+var _ = Describe("RangeStreamer", func() {
+	var server *httptest.Server
+	var requests []*http.Request
+	var streamer *query.RangeStreamer
+
+	BeforeEach(func() {
+		requests = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			requests = append(requests, r)
+
+			switch r.Form.Get("query") {
+			case "matrix":
+				fmt.Fprint(w, `{"status": "success", "warnings": ["partial response"], "data": {"resultType": "matrix", "result": [
+					{"metric": {"job": "a"}, "values": [[1, "1"], [2.5, "2"]]},
+					{"metric": {"job": "b"}, "histograms": [[1, {"count": "3", "sum": "4", "buckets": [[0, "0", "1", "3"]]}]]}
+				], "stats": {"samples": {"totalQueryableSamples": 3, "peakSamples": 2}}}}`)
+			case "empty":
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "matrix", "result": []}}`)
+			case "vector":
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": []}}`)
+			case "truncated":
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "matrix", "result": [{"metric": {"job": "a"}, "values": [[1, "1"]`)
+			case "post only":
+				if r.Method != http.MethodPost {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "matrix", "result": []}}`)
+			case "get only":
+				if r.Method != http.MethodGet {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				fmt.Fprint(w, `{"status": "success", "data": {"resultType": "matrix", "result": []}}`)
+			case "unavailable":
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, "try again later")
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"status": "error", "errorType": "bad_data", "error": "parse error"}`)
+			}
+		}))
+
+		var err error
+		streamer, err = query.NewRangeStreamer(server.URL, http.DefaultTransport)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	r := v1.Range{Start: time.Unix(1, 0), End: time.Unix(2, 0), Step: time.Second}
+
+	It("reads series the same as the Prometheus client", func() {
+		client, err := api.NewClient(api.Config{Address: server.URL})
+		Expect(err).ToNot(HaveOccurred())
+		expected, expectedWarnings, err := v1.NewAPI(client).QueryRange(context.Background(), "matrix", r)
+		Expect(err).ToNot(HaveOccurred())
+
+		matrix, warnings, err := streamer.QueryRange(context.Background(), "matrix", r)
+		Expect(err).ToNot(HaveOccurred())
+		defer matrix.Close()
+
+		Expect(warnings).To(Equal(expectedWarnings))
+		Expect(matrix.SeriesCount()).To(Equal(2))
+		Expect(readSeries(matrix)).To(Equal(expected))
+		Expect(matrix.Err()).ToNot(HaveOccurred())
+	})
+
+	It("can read the series more than once", func() {
+		matrix, _, err := streamer.QueryRange(context.Background(), "matrix", r)
+		Expect(err).ToNot(HaveOccurred())
+		defer matrix.Close()
+
+		Expect(readSeries(matrix)).To(Equal(readSeries(matrix)))
+		Expect(readSeries(matrix)).To(HaveLen(2))
+	})
+
+	It("sends the range like the Prometheus client", func() {
+		matrix, _, err := streamer.QueryRange(context.Background(), "empty", v1.Range{
+			Start: time.Unix(1, 500000000),
+			End:   time.Unix(2, 0),
+			Step:  1500 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		matrix.Close()
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].URL.Path).To(Equal("/api/v1/query_range"))
+		Expect(requests[0].Form.Get("start")).To(Equal("1.5"))
+		Expect(requests[0].Form.Get("end")).To(Equal("2"))
+		Expect(requests[0].Form.Get("step")).To(Equal("1.5"))
+		Expect(requests[0].Form.Has("stats")).To(BeFalse())
+	})
+
+	It("POSTs queries", func() {
+		matrix, _, err := streamer.QueryRange(context.Background(), "post only", r)
+		Expect(err).ToNot(HaveOccurred())
+		matrix.Close()
+
+		Expect(requests).To(HaveLen(1))
+	})
+
+	It("falls back to GET if the server doesn't allow POSTs", func() {
+		matrix, _, err := streamer.QueryRange(context.Background(), "get only", r)
+		Expect(err).ToNot(HaveOccurred())
+		matrix.Close()
+
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[1].Method).To(Equal(http.MethodGet))
+	})
+
+	It("collects the stats of the query", func() {
+		ctx, stats := query.WithStats(context.Background())
+
+		matrix, _, err := streamer.QueryRange(ctx, "matrix", r)
+		Expect(err).ToNot(HaveOccurred())
+		matrix.Close()
+
+		Expect(requests[0].Form.Get("stats")).To(Equal("all"))
+		Expect(stats.Found).To(BeTrue())
+		Expect(stats.Samples.TotalQueryableSamples).To(Equal(int64(3)))
+	})
+
+	It("returns errors from Prometheus like the Prometheus client", func() {
+		_, _, err := streamer.QueryRange(context.Background(), "invalid", r)
+
+		Expect(err).To(Equal(&v1.Error{Type: v1.ErrBadData, Msg: "parse error"}))
+	})
+
+	It("returns other failed responses like the Prometheus client", func() {
+		_, _, err := streamer.QueryRange(context.Background(), "unavailable", r)
+
+		Expect(err).To(Equal(&v1.Error{Type: v1.ErrServer, Msg: "server error: 503", Detail: "try again later"}))
+	})
+
+	It("returns an error for results that aren't a matrix", func() {
+		_, _, err := streamer.QueryRange(context.Background(), "vector", r)
+
+		Expect(err).To(MatchError(ContainSubstring(`expected a matrix result, got "vector"`)))
+	})
+
+	It("returns an error for a truncated response", func() {
+		_, _, err := streamer.QueryRange(context.Background(), "truncated", r)
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns the error of a cancelled query", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, _, err := streamer.QueryRange(ctx, "matrix", r)
+
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+	})
+})
@@ -0,0 +1,172 @@
+package receive
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// Receiver accepts Prometheus remote-write requests, keeping the latest sample of each series that
+// matches all of its matchers.
+type Receiver struct {
+	matchers []*labels.Matcher
+
+	mu       sync.Mutex
+	latest   map[string]*model.Sample
+	requests int
+	skipped  int
+	changed  bool
+}
+
+func NewReceiver(matchers []*labels.Matcher) *Receiver {
+	return &Receiver{
+		matchers: matchers,
+		latest:   make(map[string]*model.Sample),
+	}
+}
+
+// Stats summarizes what has been received so far.
+type Stats struct {
+	Requests int
+	Series   int
+
+	// Native histogram samples can't be shown, so they are only counted
+	Skipped int
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "remote write requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var written, skipped int
+	var err error
+	if strings.Contains(req.Header.Get("Content-Type"), "io.prometheus.write.v2.Request") {
+		written, skipped, err = r.receiveV2(req)
+	} else {
+		written, skipped, err = r.receiveV1(req)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Remote write 2.0 senders check what was written
+	w.Header().Set("X-Prometheus-Remote-Write-Samples-Written", strconv.Itoa(written))
+	w.Header().Set("X-Prometheus-Remote-Write-Histograms-Written", "0")
+	w.Header().Set("X-Prometheus-Remote-Write-Exemplars-Written", "0")
+	w.WriteHeader(http.StatusNoContent)
+
+	r.mu.Lock()
+	r.requests++
+	r.skipped += skipped
+	r.changed = true
+	r.mu.Unlock()
+}
+
+func (r *Receiver) receiveV1(req *http.Request) (written, skipped int, err error) {
+	writeRequest, err := remote.DecodeWriteRequest(req.Body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode remote write request: %s", err)
+	}
+
+	builder := labels.NewScratchBuilder(0)
+	for _, series := range writeRequest.Timeseries {
+		lset := series.ToLabels(&builder, nil)
+
+		for _, sample := range series.Samples {
+			r.add(lset, sample.Timestamp, sample.Value)
+		}
+
+		written += len(series.Samples)
+		skipped += len(series.Histograms)
+	}
+
+	return
+}
+
+func (r *Receiver) receiveV2(req *http.Request) (written, skipped int, err error) {
+	writeRequest, err := remote.DecodeWriteV2Request(req.Body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode remote write 2.0 request: %s", err)
+	}
+
+	builder := labels.NewScratchBuilder(0)
+	for _, series := range writeRequest.Timeseries {
+		lset, err := series.ToLabels(&builder, writeRequest.Symbols)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to decode labels: %s", err)
+		}
+
+		for _, sample := range series.Samples {
+			r.add(lset, sample.Timestamp, sample.Value)
+		}
+
+		written += len(series.Samples)
+		skipped += len(series.Histograms)
+	}
+
+	return
+}
+
+func (r *Receiver) add(lset labels.Labels, timestamp int64, value float64) {
+	for _, matcher := range r.matchers {
+		if !matcher.Matches(lset.Get(matcher.Name)) {
+			return
+		}
+	}
+
+	metric := make(model.Metric, lset.Len())
+	lset.Range(func(l labels.Label) {
+		metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := lset.String()
+	// Senders can retry or reorder batches, so an older sample never replaces a newer one
+	if previous, ok := r.latest[key]; ok && int64(previous.Timestamp) > timestamp {
+		return
+	}
+
+	r.latest[key] = &model.Sample{
+		Metric:    metric,
+		Value:     model.SampleValue(value),
+		Timestamp: model.Time(timestamp),
+	}
+}
+
+// Latest returns the newest sample of each series received so far, sorted by labels, and whether
+// anything has been received since the last call.
+func (r *Receiver) Latest() (samples model.Vector, stats Stats, changed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sample := range r.latest {
+		samples = append(samples, sample)
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Metric.Before(samples[j].Metric)
+	})
+
+	stats = Stats{
+		Requests: r.requests,
+		Series:   len(r.latest),
+		Skipped:  r.skipped,
+	}
+	changed = r.changed
+	r.changed = false
+
+	return
+}
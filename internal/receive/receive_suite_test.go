@@ -0,0 +1,13 @@
+package receive_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestReceive(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Receive Suite")
+}
@@ -0,0 +1,127 @@
+package receive_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/receive"
+)
+
+func post(receiver *receive.Receiver, contentType string, message proto.Marshaler) *httptest.ResponseRecorder {
+	contents, err := message.Marshal()
+	Expect(err).ToNot(HaveOccurred())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, contents)))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", "snappy")
+
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func v1Series(job string, samples ...prompb.Sample) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: job}},
+		Samples: samples,
+	}
+}
+
+var _ = Describe("Receiver", func() {
+	It("keeps the newest sample of each series", func() {
+		receiver := receive.NewReceiver(nil)
+
+		response := post(receiver, "application/x-protobuf", &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				v1Series("b", prompb.Sample{Timestamp: 1000, Value: 1}, prompb.Sample{Timestamp: 2000, Value: 0}),
+				v1Series("a", prompb.Sample{Timestamp: 1000, Value: 1}),
+			},
+		})
+		Expect(response.Code).To(Equal(http.StatusNoContent))
+
+		// A retried batch doesn't replace newer samples
+		post(receiver, "application/x-protobuf", &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				v1Series("b", prompb.Sample{Timestamp: 1000, Value: 1}),
+			},
+		})
+
+		samples, stats, changed := receiver.Latest()
+
+		Expect(changed).To(BeTrue())
+		Expect(stats).To(Equal(receive.Stats{Requests: 2, Series: 2}))
+		Expect(samples).To(Equal(model.Vector{
+			{Metric: model.Metric{"__name__": "up", "job": "a"}, Value: 1, Timestamp: 1000},
+			{Metric: model.Metric{"__name__": "up", "job": "b"}, Value: 0, Timestamp: 2000},
+		}))
+
+		_, _, changed = receiver.Latest()
+		Expect(changed).To(BeFalse())
+	})
+
+	It("only keeps series matching all of its matchers", func() {
+		receiver := receive.NewReceiver([]*labels.Matcher{
+			labels.MustNewMatcher(labels.MatchEqual, "job", "a"),
+		})
+
+		post(receiver, "application/x-protobuf", &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				v1Series("a", prompb.Sample{Timestamp: 1000, Value: 1}),
+				v1Series("b", prompb.Sample{Timestamp: 1000, Value: 1}),
+			},
+		})
+
+		samples, _, _ := receiver.Latest()
+
+		Expect(samples).To(HaveLen(1))
+		Expect(samples[0].Metric["job"]).To(Equal(model.LabelValue("a")))
+	})
+
+	It("decodes remote write 2.0 requests", func() {
+		receiver := receive.NewReceiver(nil)
+
+		response := post(receiver, "application/x-protobuf;proto=io.prometheus.write.v2.Request", &writev2.Request{
+			Symbols: []string{"", "__name__", "up", "job", "a"},
+			Timeseries: []writev2.TimeSeries{
+				{
+					LabelsRefs: []uint32{1, 2, 3, 4},
+					Samples:    []writev2.Sample{{Timestamp: 1000, Value: 1}},
+				},
+			},
+		})
+		Expect(response.Code).To(Equal(http.StatusNoContent))
+		Expect(response.Header().Get("X-Prometheus-Remote-Write-Samples-Written")).To(Equal("1"))
+
+		samples, _, _ := receiver.Latest()
+
+		Expect(samples).To(Equal(model.Vector{
+			{Metric: model.Metric{"__name__": "up", "job": "a"}, Value: 1, Timestamp: 1000},
+		}))
+	})
+
+	It("rejects requests it can't decode", func() {
+		receiver := receive.NewReceiver(nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader([]byte("nonsense")))
+		recorder := httptest.NewRecorder()
+		receiver.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+
+		_, stats, changed := receiver.Latest()
+		Expect(changed).To(BeFalse())
+		Expect(stats.Requests).To(Equal(0))
+	})
+})
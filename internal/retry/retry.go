@@ -0,0 +1,117 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultInitialBackoff = 500 * time.Millisecond
+	maxBackoff            = 30 * time.Second
+)
+
+// RoundTripper retries requests that fail with a network error, a 5xx response or a 429 response,
+// waiting twice as long before each attempt, or as long as the server asks with `Retry-After`.
+// Retries stop early if the next attempt would start after the request's deadline.
+type RoundTripper struct {
+	RoundTripper   http.RoundTripper
+	Retries        int
+	InitialBackoff time.Duration
+}
+
+func NewRoundTripper(roundTripper http.RoundTripper, retries int) *RoundTripper {
+	return &RoundTripper{
+		RoundTripper:   roundTripper,
+		Retries:        retries,
+		InitialBackoff: DefaultInitialBackoff,
+	}
+}
+
+func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := r.InitialBackoff
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt != 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := r.RoundTripper.RoundTrip(attemptReq)
+		if attempt == r.Retries || !shouldRetry(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		// Requests with a body can only be retried if it can be read again, so otherwise the
+		// response is passed on while it can still be read
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		wait := backoff
+		if retryAfter, ok := parseRetryAfter(resp); ok {
+			wait = retryAfter
+		}
+		backoff = min(backoff*2, maxBackoff)
+
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// Errors caused by the request's context won't go away by trying again
+		return ctx.Err() == nil
+	}
+
+	// 501 means a POST isn't supported, which the Prometheus client handles by trying a GET
+	if resp.StatusCode == http.StatusNotImplemented {
+		return false
+	}
+
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// parseRetryAfter reads `Retry-After` as either a number of seconds or an HTTP date.
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,148 @@
+package retry_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/retry"
+)
+
+var _ = Describe("RoundTripper", func() {
+	var server *httptest.Server
+	var statuses []int
+	var headers http.Header
+	var bodies []string
+
+	BeforeEach(func() {
+		statuses = nil
+		headers = http.Header{}
+		bodies = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+
+			status := http.StatusOK
+			if len(bodies) <= len(statuses) {
+				status = statuses[len(bodies)-1]
+			}
+
+			for name, values := range headers {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(status)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newClient := func(retries int) *http.Client {
+		roundTripper := retry.NewRoundTripper(http.DefaultTransport, retries)
+		roundTripper.InitialBackoff = time.Millisecond
+
+		return &http.Client{Transport: roundTripper}
+	}
+
+	It("retries server errors and rate limits", func() {
+		statuses = []int{http.StatusBadGateway, http.StatusTooManyRequests}
+
+		resp, err := newClient(2).Get(server.URL)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(bodies).To(HaveLen(3))
+	})
+
+	It("gives up after the given number of retries", func() {
+		statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+
+		resp, err := newClient(1).Get(server.URL)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(bodies).To(HaveLen(2))
+	})
+
+	It("doesn't retry client errors", func() {
+		statuses = []int{http.StatusUnauthorized}
+
+		resp, err := newClient(3).Get(server.URL)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(bodies).To(HaveLen(1))
+	})
+
+	It("sends the body again with each attempt", func() {
+		statuses = []int{http.StatusServiceUnavailable}
+
+		resp, err := newClient(1).Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("query=up"))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(bodies).To(Equal([]string{"query=up", "query=up"}))
+	})
+
+	It("passes on the response when the body can't be sent again", func() {
+		statuses = []int{http.StatusServiceUnavailable}
+
+		// Only bodies from known readers can be read again
+		req, err := http.NewRequest(http.MethodPost, server.URL, ioutil.NopCloser(strings.NewReader("query=up")))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(req.GetBody).To(BeNil())
+
+		resp, err := newClient(1).Do(req)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(bodies).To(Equal([]string{"query=up"}))
+	})
+
+	It("waits as long as Retry-After asks", func() {
+		statuses = []int{http.StatusTooManyRequests}
+		headers.Set("Retry-After", "1")
+
+		start := time.Now()
+		resp, err := newClient(1).Get(server.URL)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+	})
+
+	It("stops retrying if the next attempt would be after the deadline", func() {
+		statuses = []int{http.StatusTooManyRequests}
+		headers.Set("Retry-After", "60")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		resp, err := newClient(1).Do(req)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(bodies).To(HaveLen(1))
+	})
+
+	It("retries network errors", func() {
+		server.Close()
+
+		start := time.Now()
+		_, err := newClient(2).Get(server.URL)
+
+		Expect(err).To(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically(">=", 3*time.Millisecond))
+	})
+})
@@ -0,0 +1,169 @@
+package scrape
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Ask for the classic text format, as that's what the parser understands
+const acceptHeader = `text/plain;version=0.0.4;q=1,*/*;q=0.1`
+
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples model.Vector
+}
+
+// Fetch scrapes the metrics endpoint at the given URL.
+func Fetch(url string, roundTripper http.RoundTripper, timeout time.Duration) ([]Family, error) {
+	client := &http.Client{
+		Transport: roundTripper,
+		Timeout:   timeout,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	return Parse(resp.Body, time.Now())
+}
+
+// Parse reads metrics in the Prometheus text format, returning each metric family in order of name.
+// Samples without a timestamp are given the time `now`.
+func Parse(in io.Reader, now time.Time) ([]Family, error) {
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+
+	metricFamilies, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range metricFamilies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	decodeOptions := &expfmt.DecodeOptions{
+		Timestamp: model.TimeFromUnixNano(now.UnixNano()),
+	}
+
+	var families []Family
+	for _, name := range names {
+		metricFamily := metricFamilies[name]
+
+		samples, err := expfmt.ExtractSamples(decodeOptions, metricFamily)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", name, err)
+		}
+
+		families = append(families, Family{
+			Name:    name,
+			Help:    metricFamily.GetHelp(),
+			Type:    strings.ToLower(metricFamily.GetType().String()),
+			Samples: samples,
+		})
+	}
+
+	return families, nil
+}
+
+// Samples returns the samples of all the given families as one vector.
+func Samples(families []Family) (result model.Vector) {
+	for _, family := range families {
+		result = append(result, family.Samples...)
+	}
+
+	return
+}
+
+// Rates compares two scrapes of the same endpoint. Counters, and the sums and counts of histograms
+// and summaries, become per-second rates, sorted from highest to lowest. Gauges, untyped metrics
+// and summary quantiles keep their current values. Series that only appear in one scrape are left
+// out of the rates.
+func Rates(previous, current []Family) (rates model.Vector, gauges model.Vector) {
+	previousSamples := make(map[model.Fingerprint]*model.Sample)
+	for _, family := range previous {
+		for _, sample := range family.Samples {
+			previousSamples[sample.Metric.Fingerprint()] = sample
+		}
+	}
+
+	for _, family := range current {
+		for _, sample := range family.Samples {
+			if !isCounter(family, sample) {
+				// The quantiles of summaries are current values, like gauges
+				if family.Type == "gauge" || family.Type == "untyped" || family.Type == "summary" {
+					gauges = append(gauges, sample)
+				}
+
+				continue
+			}
+
+			previousSample, ok := previousSamples[sample.Metric.Fingerprint()]
+			if !ok {
+				continue
+			}
+
+			elapsed := sample.Timestamp.Sub(previousSample.Timestamp).Seconds()
+			if elapsed <= 0 {
+				continue
+			}
+
+			increase := sample.Value - previousSample.Value
+			if increase < 0 {
+				// The counter was reset, so it's counted from 0 like rate() does
+				increase = sample.Value
+			}
+
+			rates = append(rates, &model.Sample{
+				Metric:    sample.Metric,
+				Value:     increase / model.SampleValue(elapsed),
+				Timestamp: sample.Timestamp,
+			})
+		}
+	}
+
+	sort.SliceStable(rates, func(i, j int) bool {
+		if rates[i].Value != rates[j].Value {
+			return rates[i].Value > rates[j].Value
+		}
+
+		return rates[i].Metric.Before(rates[j].Metric)
+	})
+
+	return
+}
+
+func isCounter(family Family, sample *model.Sample) bool {
+	switch family.Type {
+	case "counter":
+		return true
+	case "histogram", "summary":
+		name := string(sample.Metric[model.MetricNameLabel])
+
+		return strings.HasSuffix(name, "_sum") || strings.HasSuffix(name, "_count")
+	}
+
+	return false
+}
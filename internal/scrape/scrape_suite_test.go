@@ -0,0 +1,13 @@
+package scrape_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestScrape(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Scrape Suite")
+}
@@ -0,0 +1,169 @@
+package scrape_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pianohacker/quickprom/internal/scrape"
+)
+
+const exposition = `# HELP up Whether the target is up.
+# TYPE up gauge
+up{job="a"} 1
+# HELP req_seconds Request latency.
+# TYPE req_seconds histogram
+req_seconds_bucket{le="0.5"} 3
+req_seconds_bucket{le="+Inf"} 4
+req_seconds_sum 1.5
+req_seconds_count 4
+started 12 1000
+`
+
+var _ = Describe("Scrape", func() {
+	Describe("Parse()", func() {
+		It("returns each family sorted by name", func() {
+			now := time.Unix(2, 0)
+			families, err := scrape.Parse(strings.NewReader(exposition), now)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(families).To(HaveLen(3))
+
+			Expect(families[0].Name).To(Equal("req_seconds"))
+			Expect(families[0].Help).To(Equal("Request latency."))
+			Expect(families[0].Type).To(Equal("histogram"))
+			Expect(families[0].Samples).To(ContainElement(&model.Sample{
+				Metric:    model.Metric{"__name__": "req_seconds_bucket", "le": "0.5"},
+				Value:     3,
+				Timestamp: 2000,
+			}))
+			Expect(families[0].Samples).To(HaveLen(4))
+
+			Expect(families[1].Name).To(Equal("started"))
+			Expect(families[1].Type).To(Equal("untyped"))
+			Expect(families[1].Samples[0].Timestamp).To(Equal(model.Time(1000)))
+
+			Expect(families[2].Samples).To(Equal(model.Vector{
+				{Metric: model.Metric{"__name__": "up", "job": "a"}, Value: 1, Timestamp: 2000},
+			}))
+
+			Expect(scrape.Samples(families)).To(HaveLen(6))
+		})
+
+		It("returns an error for invalid input", func() {
+			_, err := scrape.Parse(strings.NewReader("up{"), time.Now())
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Rates()", func() {
+		parse := func(exposition string, now time.Time) []scrape.Family {
+			families, err := scrape.Parse(strings.NewReader(exposition), now)
+			Expect(err).ToNot(HaveOccurred())
+
+			return families
+		}
+
+		It("turns counters into per-second rates, sorted by rate", func() {
+			previous := parse(`# TYPE reqs counter
+reqs{code="200"} 100
+reqs{code="500"} 10
+reqs{code="404"} 50
+# TYPE temp gauge
+temp 20
+`, time.Unix(0, 0))
+			current := parse(`# TYPE reqs counter
+reqs{code="200"} 120
+reqs{code="500"} 50
+reqs{code="404"} 5
+reqs{code="503"} 1
+# TYPE temp gauge
+temp 21
+`, time.Unix(2, 0))
+
+			rates, gauges := scrape.Rates(previous, current)
+
+			Expect(rates).To(Equal(model.Vector{
+				{Metric: model.Metric{"__name__": "reqs", "code": "500"}, Value: 20, Timestamp: 2000},
+				{Metric: model.Metric{"__name__": "reqs", "code": "200"}, Value: 10, Timestamp: 2000},
+				// Reset
+				{Metric: model.Metric{"__name__": "reqs", "code": "404"}, Value: 2.5, Timestamp: 2000},
+			}))
+			Expect(gauges).To(Equal(model.Vector{
+				{Metric: model.Metric{"__name__": "temp"}, Value: 21, Timestamp: 2000},
+			}))
+		})
+
+		It("only takes the rates of sums and counts of histograms", func() {
+			histogram := `# TYPE req_seconds histogram
+req_seconds_bucket{le="+Inf"} %d
+req_seconds_sum %d
+req_seconds_count %d
+`
+			previous := parse(fmt.Sprintf(histogram, 1, 1, 1), time.Unix(0, 0))
+			current := parse(fmt.Sprintf(histogram, 5, 3, 5), time.Unix(1, 0))
+
+			rates, gauges := scrape.Rates(previous, current)
+
+			Expect(rates).To(Equal(model.Vector{
+				{Metric: model.Metric{"__name__": "req_seconds_count"}, Value: 4, Timestamp: 1000},
+				{Metric: model.Metric{"__name__": "req_seconds_sum"}, Value: 2, Timestamp: 1000},
+			}))
+			Expect(gauges).To(BeEmpty())
+		})
+
+		It("keeps the current values of summary quantiles", func() {
+			summary := `# TYPE req_seconds summary
+req_seconds{quantile="0.5"} %d
+req_seconds{quantile="0.99"} %d
+req_seconds_sum %d
+req_seconds_count %d
+`
+			previous := parse(fmt.Sprintf(summary, 1, 2, 1, 1), time.Unix(0, 0))
+			current := parse(fmt.Sprintf(summary, 3, 4, 3, 5), time.Unix(1, 0))
+
+			rates, gauges := scrape.Rates(previous, current)
+
+			Expect(rates).To(Equal(model.Vector{
+				{Metric: model.Metric{"__name__": "req_seconds_count"}, Value: 4, Timestamp: 1000},
+				{Metric: model.Metric{"__name__": "req_seconds_sum"}, Value: 2, Timestamp: 1000},
+			}))
+			Expect(gauges).To(ConsistOf(
+				&model.Sample{Metric: model.Metric{"__name__": "req_seconds", "quantile": "0.5"}, Value: 3, Timestamp: 1000},
+				&model.Sample{Metric: model.Metric{"__name__": "req_seconds", "quantile": "0.99"}, Value: 4, Timestamp: 1000},
+			))
+		})
+	})
+
+	Describe("Fetch()", func() {
+		It("asks for the text format", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Accept")).To(HavePrefix("text/plain"))
+				fmt.Fprint(w, exposition)
+			}))
+			defer server.Close()
+
+			families, err := scrape.Fetch(server.URL, http.DefaultTransport, time.Second)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(families).To(HaveLen(3))
+		})
+
+		It("returns an error when the server fails", func() {
+			server := httptest.NewServer(http.NotFoundHandler())
+			defer server.Close()
+
+			_, err := scrape.Fetch(server.URL, http.DefaultTransport, time.Second)
+
+			Expect(err).To(MatchError(ContainSubstring("404")))
+		})
+	})
+})